@@ -0,0 +1,234 @@
+// analysis.compare.go
+// compares two simulations, aligning their commodities, industries and classes by name
+
+package analysis
+
+import (
+	"capfront/models"
+	"encoding/csv"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Percentage differences at least this large are highlighted
+const SignificantPercent = 1.0
+
+// The difference in one numeric field between two objects of the same name
+type Difference struct {
+	Field      string
+	A          float64
+	B          float64
+	Absolute   float64 // B - A
+	Percent    float64 // the absolute difference as a percentage of A
+	HasPercent bool    // false if A is zero, so there is no percentage
+}
+
+// True if the difference is large enough to draw attention to
+func (d Difference) Significant() bool {
+	if !d.HasPercent {
+		return d.Absolute != 0
+	}
+	return math.Abs(d.Percent) >= SignificantPercent
+}
+
+// "up", "down" or "" according to the direction of a significant difference, for highlighting
+func (d Difference) Direction() string {
+	switch {
+	case !d.Significant():
+		return ""
+	case d.Absolute > 0:
+		return "up"
+	default:
+		return "down"
+	}
+}
+
+// One commodity, industry or class, as it appears in both simulations
+type ComparedObject struct {
+	Name        string
+	InA         bool
+	InB         bool
+	Differences []Difference
+}
+
+// A parameter of the simulations themselves
+type ComparedParameter struct {
+	Name string
+	A    string
+	B    string
+}
+
+func (p ComparedParameter) Differs() bool {
+	return p.A != p.B
+}
+
+// A comparison of two simulations
+type Comparison struct {
+	A           models.Simulation
+	B           models.Simulation
+	Parameters  []ComparedParameter
+	Commodities []ComparedObject
+	Industries  []ComparedObject
+	Classes     []ComparedObject
+}
+
+// The compared objects of one kind, with a heading for display
+type ComparedGroup struct {
+	Kind    string
+	Heading string
+	Objects []ComparedObject
+}
+
+// The commodities, industries and classes, in that order
+func (c Comparison) Groups() []ComparedGroup {
+	return []ComparedGroup{
+		{"commodity", "Commodities", c.Commodities},
+		{"industry", "Industries", c.Industries},
+		{"class", "Classes", c.Classes},
+	}
+}
+
+// A numeric field of objects of type T, with the name to show for it
+type field[T any] struct {
+	name  string
+	value func(T) float32
+}
+
+var commodityFields = []field[models.Commodity]{
+	{"Size", func(c models.Commodity) float32 { return c.Size }},
+	{"Total Value", func(c models.Commodity) float32 { return c.Total_Value }},
+	{"Total Price", func(c models.Commodity) float32 { return c.Total_Price }},
+	{"Unit Value", func(c models.Commodity) float32 { return c.Unit_Value }},
+	{"Unit Price", func(c models.Commodity) float32 { return c.Unit_Price }},
+	{"Demand", func(c models.Commodity) float32 { return c.Demand }},
+	{"Supply", func(c models.Commodity) float32 { return c.Supply }},
+}
+
+var industryFields = []field[models.Industry]{
+	{"Output Scale", func(i models.Industry) float32 { return i.Output_Scale }},
+	{"Initial Capital", func(i models.Industry) float32 { return i.Initial_Capital }},
+	{"Current Capital", func(i models.Industry) float32 { return i.Current_Capital }},
+	{"Profit", func(i models.Industry) float32 { return i.Profit }},
+	{"Profit Rate", func(i models.Industry) float32 { return i.Profit_Rate }},
+}
+
+var classFields = []field[models.Class]{
+	{"Population", func(c models.Class) float32 { return c.Population }},
+	{"Consumption Ratio", func(c models.Class) float32 { return c.Consumption_Ratio }},
+	{"Revenue", func(c models.Class) float32 { return c.Revenue }},
+	{"Assets", func(c models.Class) float32 { return c.Assets }},
+}
+
+// Compares simulations a and b, whose objects are found (among others) in the given lists.
+func Compare(a models.Simulation, b models.Simulation, commodities []models.Commodity, industries []models.Industry, classes []models.Class) Comparison {
+	return Comparison{
+		A:          a,
+		B:          b,
+		Parameters: compareParameters(a, b),
+		Commodities: compareByName(commodities, a.Id, b.Id, commodityFields,
+			func(c models.Commodity) (string, int) { return c.Name, int(c.Simulation_id) }),
+		Industries: compareByName(industries, a.Id, b.Id, industryFields,
+			func(i models.Industry) (string, int) { return i.Name, int(i.Simulation_id) }),
+		Classes: compareByName(classes, a.Id, b.Id, classFields,
+			func(c models.Class) (string, int) { return c.Name, int(c.Simulation_id) }),
+	}
+}
+
+// helper function comparing the parameters of two simulations
+func compareParameters(a models.Simulation, b models.Simulation) []ComparedParameter {
+	number := func(f float32) string { return strconv.FormatFloat(float64(f), 'g', -1, 32) }
+	return []ComparedParameter{
+		{"Periods Per Year", number(a.Periods_Per_Year), number(b.Periods_Per_Year)},
+		{"Population Growth Rate", number(a.Population_Growth_Rate), number(b.Population_Growth_Rate)},
+		{"Investment Ratio", number(a.Investment_Ratio), number(b.Investment_Ratio)},
+		{"Labour Supply Response", a.Labour_Supply_Demand, b.Labour_Supply_Demand},
+		{"Price Response Type", a.Price_Response_Type, b.Price_Response_Type},
+		{"Melt Response Type", a.Melt_Response_Type, b.Melt_Response_Type},
+		{"Melt", number(a.Melt), number(b.Melt)},
+		{"State", a.State, b.State},
+	}
+}
+
+// helper function aligning the objects of simulations a and b by name and comparing their fields.
+// identify gives the name of an object and the simulation it belongs to.
+// Objects are listed in alphabetical order of name.
+func compareByName[T any](list []T, a int, b int, fields []field[T], identify func(T) (string, int)) []ComparedObject {
+	inA := make(map[string]T)
+	inB := make(map[string]T)
+	for _, item := range list {
+		switch name, simulation := identify(item); simulation {
+		case a:
+			inA[name] = item
+		case b:
+			inB[name] = item
+		}
+	}
+
+	names := make([]string, 0, len(inA)+len(inB))
+	for name := range inA {
+		names = append(names, name)
+	}
+	for name := range inB {
+		if _, ok := inA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var result []ComparedObject
+	for _, name := range names {
+		x, okA := inA[name]
+		y, okB := inB[name]
+		object := ComparedObject{Name: name, InA: okA, InB: okB}
+		for _, f := range fields {
+			d := Difference{Field: f.name}
+			if okA {
+				d.A = float64(f.value(x))
+			}
+			if okB {
+				d.B = float64(f.value(y))
+			}
+			d.Absolute = d.B - d.A
+			if d.A != 0 {
+				d.Percent = 100 * d.Absolute / math.Abs(d.A)
+				d.HasPercent = true
+			}
+			object.Differences = append(object.Differences, d)
+		}
+		result = append(result, object)
+	}
+	return result
+}
+
+// Writes the comparison as CSV, one row for each parameter and for each field of each object
+func (c Comparison) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	number := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+	out.Write([]string{"kind", "name", "field", c.A.Name + " (" + strconv.Itoa(c.A.Id) + ")", c.B.Name + " (" + strconv.Itoa(c.B.Id) + ")", "difference", "percent"})
+	for _, p := range c.Parameters {
+		out.Write([]string{"parameter", "", p.Name, p.A, p.B, "", ""})
+	}
+	for _, group := range c.Groups() {
+		for _, object := range group.Objects {
+			for _, d := range object.Differences {
+				percent := ""
+				if d.HasPercent {
+					percent = number(d.Percent)
+				}
+				out.Write([]string{group.Kind, object.Name, d.Field, present(object.InA, d.A), present(object.InB, d.B), number(d.Absolute), percent})
+			}
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// helper function giving a value for CSV, or a blank if the object does not exist in that simulation
+func present(exists bool, value float64) string {
+	if !exists {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,128 @@
+// analysis.diff.go
+// works out what an action changed, by comparing the tables before and after it
+
+package analysis
+
+import (
+	"capfront/models"
+	"math"
+	"sort"
+)
+
+// Changes smaller than this are not reported
+const changeEpsilon = 1e-6
+
+// One field of one object that an action changed
+type Change struct {
+	Kind   string  `json:"kind"` // commodity, industry, class, industry stock or class stock
+	Id     int     `json:"id"`
+	Name   string  `json:"name"`
+	Field  string  `json:"field"`
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
+}
+
+// Everything that one action changed
+type ActionDiff struct {
+	Action     string   `json:"action"`
+	Simulation int      `json:"simulation"`
+	Changes    []Change `json:"changes"`
+}
+
+var diffIndustryFields = []field[models.Industry]{
+	{"Output Scale", func(i models.Industry) float32 { return i.Output_Scale }},
+	{"Work In Progress", func(i models.Industry) float32 { return i.Work_In_Progress }},
+	{"Current Capital", func(i models.Industry) float32 { return i.Current_Capital }},
+	{"Profit", func(i models.Industry) float32 { return i.Profit }},
+	{"Profit Rate", func(i models.Industry) float32 { return i.Profit_Rate }},
+}
+
+var diffClassFields = []field[models.Class]{
+	{"Population", func(c models.Class) float32 { return c.Population }},
+	{"Revenue", func(c models.Class) float32 { return c.Revenue }},
+	{"Assets", func(c models.Class) float32 { return c.Assets }},
+}
+
+var industryStockFields = []field[models.Industry_Stock]{
+	{"Size", func(s models.Industry_Stock) float32 { return s.Size }},
+	{"Value", func(s models.Industry_Stock) float32 { return s.Value }},
+	{"Price", func(s models.Industry_Stock) float32 { return s.Price }},
+	{"Demand", func(s models.Industry_Stock) float32 { return s.Demand }},
+}
+
+var classStockFields = []field[models.Class_Stock]{
+	{"Size", func(s models.Class_Stock) float32 { return s.Size }},
+	{"Value", func(s models.Class_Stock) float32 { return s.Value }},
+	{"Price", func(s models.Class_Stock) float32 { return s.Price }},
+	{"Demand", func(s models.Class_Stock) float32 { return s.Demand }},
+}
+
+// Works out what the action recorded in t changed.
+// Objects are matched by id; objects that exist only before or only after are ignored.
+func Diff(t models.Transition) ActionDiff {
+	sim := t.After.Simulation
+	industryNames := make(map[int]string)
+	for _, ind := range t.After.IndustryList {
+		industryNames[ind.Id] = ind.Name
+	}
+	classNames := make(map[int]string)
+	for _, class := range t.After.ClassList {
+		classNames[class.Id] = class.Name
+	}
+
+	diff := ActionDiff{Action: t.Action, Simulation: sim}
+	diff.Changes = append(diff.Changes, diffByID("commodity", sim, t.Before.CommodityList, t.After.CommodityList, commodityFields,
+		func(c models.Commodity) (int, int, string) { return c.Id, int(c.Simulation_id), c.Name })...)
+	diff.Changes = append(diff.Changes, diffByID("industry", sim, t.Before.IndustryList, t.After.IndustryList, diffIndustryFields,
+		func(i models.Industry) (int, int, string) { return i.Id, int(i.Simulation_id), i.Name })...)
+	diff.Changes = append(diff.Changes, diffByID("class", sim, t.Before.ClassList, t.After.ClassList, diffClassFields,
+		func(c models.Class) (int, int, string) { return c.Id, int(c.Simulation_id), c.Name })...)
+	diff.Changes = append(diff.Changes, diffByID("industry stock", sim, t.Before.IndustryStockList, t.After.IndustryStockList, industryStockFields,
+		func(s models.Industry_Stock) (int, int, string) {
+			return s.Id, s.Simulation_id, stockName(industryNames[s.Industry_id], s.Name, s.Usage_type)
+		})...)
+	diff.Changes = append(diff.Changes, diffByID("class stock", sim, t.Before.ClassStockList, t.After.ClassStockList, classStockFields,
+		func(s models.Class_Stock) (int, int, string) {
+			return s.Id, s.Simulation_id, stockName(classNames[s.Class_id], s.Name, s.Usage_type)
+		})...)
+	return diff
+}
+
+// helper function naming a stock after its owner, since stocks of different owners often share a name
+func stockName(owner string, name string, usage string) string {
+	if name == "" {
+		name = usage
+	}
+	if owner == "" {
+		return name
+	}
+	return owner + ": " + name
+}
+
+// helper function matching the objects of one simulation before and after by id, and listing
+// each field that changed. identify gives the id, simulation and name of an object.
+func diffByID[T any](kind string, simulation int, before []T, after []T, fields []field[T], identify func(T) (int, int, string)) []Change {
+	previous := make(map[int]T)
+	for _, item := range before {
+		if id, sim, _ := identify(item); sim == simulation {
+			previous[id] = item
+		}
+	}
+	var changes []Change
+	for _, item := range after {
+		id, sim, name := identify(item)
+		old, ok := previous[id]
+		if !ok || sim != simulation {
+			continue
+		}
+		for _, f := range fields {
+			x, y := float64(f.value(old)), float64(f.value(item))
+			if math.Abs(y-x) > changeEpsilon {
+				changes = append(changes, Change{Kind: kind, Id: id, Name: name, Field: f.name, Before: x, After: y, Delta: y - x})
+			}
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
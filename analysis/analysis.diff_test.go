@@ -0,0 +1,110 @@
+// analysis.diff_test.go
+// tests of what an action is reported to have changed
+
+package analysis
+
+import (
+	"capfront/models"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	before := models.Snapshot{
+		Simulation: 1,
+		CommodityList: []models.Commodity{
+			{Id: 1, Simulation_id: 1, Name: "Means of Production", Size: 100, Unit_Value: 2},
+			{Id: 2, Simulation_id: 1, Name: "Gone", Size: 5},
+			{Id: 9, Simulation_id: 2, Name: "Other", Size: 1},
+		},
+		IndustryList: []models.Industry{{Id: 10, Simulation_id: 1, Name: "Department I", Profit: 20}},
+		ClassList:    []models.Class{{Id: 20, Simulation_id: 1, Name: "Workers", Revenue: 0}},
+		IndustryStockList: []models.Industry_Stock{
+			{Id: 30, Simulation_id: 1, Industry_id: 10, Name: "", Usage_type: models.MoneyUsage, Size: 500},
+			{Id: 31, Simulation_id: 1, Industry_id: 10, Name: "Labour Power", Usage_type: models.ProductionUsage, Demand: 0},
+		},
+		ClassStockList: []models.Class_Stock{{Id: 40, Simulation_id: 1, Class_id: 20, Name: "Consumption", Size: 10}},
+	}
+	after := models.Snapshot{
+		Simulation: 1,
+		CommodityList: []models.Commodity{
+			{Id: 1, Simulation_id: 1, Name: "Means of Production", Size: 100.0000001, Unit_Value: 2.5},
+			{Id: 3, Simulation_id: 1, Name: "New", Size: 7},
+			{Id: 9, Simulation_id: 2, Name: "Other", Size: 50},
+		},
+		IndustryList: []models.Industry{{Id: 10, Simulation_id: 1, Name: "Department I", Profit: 25}},
+		ClassList:    []models.Class{{Id: 20, Simulation_id: 1, Name: "Workers", Revenue: 30}},
+		IndustryStockList: []models.Industry_Stock{
+			{Id: 30, Simulation_id: 1, Industry_id: 10, Name: "", Usage_type: models.MoneyUsage, Size: 470},
+			{Id: 31, Simulation_id: 1, Industry_id: 10, Name: "Labour Power", Usage_type: models.ProductionUsage, Demand: 30},
+		},
+		ClassStockList: []models.Class_Stock{{Id: 40, Simulation_id: 1, Class_id: 20, Name: "Consumption", Size: 10}},
+	}
+	diff := Diff(models.Transition{Action: "trade", Before: before, After: after})
+
+	want := []Change{
+		{Kind: "commodity", Id: 1, Name: "Means of Production", Field: "Unit Value", Before: 2, After: 2.5, Delta: 0.5},
+		{Kind: "industry", Id: 10, Name: "Department I", Field: "Profit", Before: 20, After: 25, Delta: 5},
+		{Kind: "class", Id: 20, Name: "Workers", Field: "Revenue", Before: 0, After: 30, Delta: 30},
+		{Kind: "industry stock", Id: 31, Name: "Department I: Labour Power", Field: "Demand", Before: 0, After: 30, Delta: 30},
+		{Kind: "industry stock", Id: 30, Name: "Department I: Money", Field: "Size", Before: 500, After: 470, Delta: -30},
+	}
+	if diff.Action != "trade" || diff.Simulation != 1 {
+		t.Errorf("diff is of %s in %d, want trade in 1", diff.Action, diff.Simulation)
+	}
+	if len(diff.Changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(diff.Changes), len(want), diff.Changes)
+	}
+	for i, change := range diff.Changes {
+		if change != want[i] {
+			t.Errorf("change %d is %+v, want %+v", i, change, want[i])
+		}
+	}
+}
+
+func TestDiffByID(t *testing.T) {
+	type item struct {
+		id, sim int
+		name    string
+		a, b    float32
+	}
+	fields := []field[item]{
+		{"A", func(x item) float32 { return x.a }},
+		{"B", func(x item) float32 { return x.b }},
+	}
+	identify := func(x item) (int, int, string) { return x.id, x.sim, x.name }
+	tests := []struct {
+		name   string
+		before []item
+		after  []item
+		want   []string // name and field of each change, in order
+	}{
+		{"nothing changed", []item{{1, 1, "x", 1, 2}}, []item{{1, 1, "x", 1, 2}}, nil},
+		{"both fields", []item{{1, 1, "x", 1, 2}}, []item{{1, 1, "x", 3, 4}}, []string{"x A", "x B"}},
+		{"below epsilon", []item{{1, 1, "x", 1, 2}}, []item{{1, 1, "x", 1, 2.0000001}}, nil},
+		{"matched by id, not position", []item{{1, 1, "x", 1, 0}, {2, 1, "y", 1, 0}}, []item{{2, 1, "y", 5, 0}, {1, 1, "x", 1, 0}}, []string{"y A"}},
+		{"sorted by name", []item{{1, 1, "z", 0, 0}, {2, 1, "a", 0, 0}}, []item{{1, 1, "z", 1, 0}, {2, 1, "a", 1, 0}}, []string{"a A", "z A"}},
+		{"other simulation", []item{{1, 2, "x", 1, 0}}, []item{{1, 2, "x", 9, 0}}, nil},
+		{"only after", nil, []item{{1, 1, "x", 9, 0}}, nil},
+		{"only before", []item{{1, 1, "x", 9, 0}}, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := diffByID("item", 1, test.before, test.after, fields, identify)
+			var got []string
+			for _, c := range changes {
+				got = append(got, c.Name+" "+c.Field)
+				if c.Kind != "item" || c.Delta != c.After-c.Before {
+					t.Errorf("change %+v is malformed", c)
+				}
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("changes %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("changes %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+// analysis.iotable.go
+// builds an input-output table, in the manner of Leontief and Sraffa,
+// from the industries and industry stocks of a simulation.
+//
+// The functions in this package work only on the lists they are given, and do not
+// look anything up in the list of users, so they can be used (and checked) on their own.
+
+package analysis
+
+import (
+	"capfront/models"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// How the flows of an input-output table are measured
+type Mode string
+
+const (
+	Quantities Mode = "quantities" // in the commodity's own units
+	Values     Mode = "values"     // at unit values
+	Prices     Mode = "prices"     // at unit prices
+)
+
+// Converts a string (for example from a query parameter) into a Mode.
+// Anything unrecognised is an error.
+func ParseMode(s string) (Mode, error) {
+	switch Mode(s) {
+	case Quantities, Values, Prices:
+		return Mode(s), nil
+	case "":
+		return Quantities, nil
+	}
+	return "", fmt.Errorf("unknown mode %q: must be quantities, values or prices", s)
+}
+
+// One row of an input-output table: the amounts of one commodity used by each industry
+type IORow struct {
+	Commodity string
+	Cells     []float64 // one for each industry, in the order of IOTable.Industries
+	Total     float64
+}
+
+// An input-output table.
+// Rows are the commodities used as inputs to production; columns are the industries.
+type IOTable struct {
+	Mode              Mode
+	Industries        []string  // the column headings
+	OutputCommodities []string  // what each industry produces
+	Rows              []IORow   // the flows: how much of each commodity each industry requires
+	Outputs           []float64 // how much each industry produces
+	Coefficients      []IORow   // the technical coefficients: each flow divided by the output of its industry
+}
+
+// Builds the input-output table of one simulation.
+//
+// The inputs are the Requirement of each Production stock: what the industry needs to
+// produce at its current scale. The output commodity of each industry is the commodity of
+// its Sales stock, and the amount is its Output_Scale (the sales stock itself is emptied by trade,
+// so it is not a reliable measure of output). In Values or Prices mode every amount
+// is multiplied by the unit value or unit price of its commodity.
+func BuildIOTable(mode Mode, simulationID int, commodities []models.Commodity, industries []models.Industry, stocks []models.Industry_Stock) IOTable {
+	table := IOTable{Mode: mode}
+
+	commodityByID := make(map[int]models.Commodity)
+	for _, c := range commodities {
+		if int(c.Simulation_id) == simulationID {
+			commodityByID[c.Id] = c
+		}
+	}
+
+	var columns []models.Industry
+	for _, ind := range industries {
+		if int(ind.Simulation_id) == simulationID {
+			columns = append(columns, ind)
+		}
+	}
+	sort.SliceStable(columns, func(i, j int) bool { return columns[i].Id < columns[j].Id })
+	column := make(map[int]int, len(columns)) // industry id -> column number
+	for i, ind := range columns {
+		column[ind.Id] = i
+		table.Industries = append(table.Industries, ind.Name)
+	}
+	table.OutputCommodities = make([]string, len(columns))
+	table.Outputs = make([]float64, len(columns))
+
+	flows := make(map[int][]float64) // commodity id -> one cell per industry
+	for _, s := range stocks {
+		col, ok := column[s.Industry_id]
+		if !ok || s.Simulation_id != simulationID {
+			continue
+		}
+		c := commodityByID[s.Commodity_id]
+		switch s.Usage_type {
+		case models.ProductionUsage:
+			if flows[s.Commodity_id] == nil {
+				flows[s.Commodity_id] = make([]float64, len(columns))
+			}
+			flows[s.Commodity_id][col] += measure(mode, float64(s.Requirement), c)
+		case models.SalesUsage:
+			table.OutputCommodities[col] = c.Name
+			table.Outputs[col] = measure(mode, float64(columns[col].Output_Scale), c)
+		}
+	}
+
+	// Show the commodities in the order the simulation's designer chose
+	var rowIDs []int
+	for id := range flows {
+		rowIDs = append(rowIDs, id)
+	}
+	sort.Slice(rowIDs, func(i, j int) bool {
+		a, b := commodityByID[rowIDs[i]], commodityByID[rowIDs[j]]
+		if a.Display_Order != b.Display_Order {
+			return a.Display_Order < b.Display_Order
+		}
+		return rowIDs[i] < rowIDs[j]
+	})
+
+	for _, id := range rowIDs {
+		name := commodityByID[id].Name
+		if name == "" {
+			name = "UNKNOWN COMMODITY " + strconv.Itoa(id)
+		}
+		row := IORow{Commodity: name, Cells: flows[id]}
+		coefficients := IORow{Commodity: name, Cells: make([]float64, len(columns))}
+		for col, flow := range row.Cells {
+			row.Total += flow
+			if table.Outputs[col] != 0 {
+				coefficients.Cells[col] = flow / table.Outputs[col]
+			}
+		}
+		table.Rows = append(table.Rows, row)
+		table.Coefficients = append(table.Coefficients, coefficients)
+	}
+	return table
+}
+
+// helper function converting a quantity of commodity c into the measure that mode asks for
+func measure(mode Mode, quantity float64, c models.Commodity) float64 {
+	switch mode {
+	case Values:
+		return quantity * float64(c.Unit_Value)
+	case Prices:
+		return quantity * float64(c.Unit_Price)
+	default:
+		return quantity
+	}
+}
+
+// Writes the flows of the table as CSV: a heading row of industries, a row for each
+// input commodity (with its total), and finally a row of outputs.
+// If coefficients is true, writes the technical coefficients instead of the flows.
+func (t IOTable) WriteCSV(w io.Writer, coefficients bool) error {
+	out := csv.NewWriter(w)
+	heading := append([]string{"commodity"}, t.Industries...)
+	rows := t.Rows
+	if coefficients {
+		rows = t.Coefficients
+	} else {
+		heading = append(heading, "total")
+	}
+	out.Write(heading)
+	for _, row := range rows {
+		record := []string{row.Commodity}
+		for _, cell := range row.Cells {
+			record = append(record, strconv.FormatFloat(cell, 'g', -1, 64))
+		}
+		if !coefficients {
+			record = append(record, strconv.FormatFloat(row.Total, 'g', -1, 64))
+		}
+		out.Write(record)
+	}
+	if !coefficients {
+		record := []string{"output"}
+		for _, output := range t.Outputs {
+			record = append(record, strconv.FormatFloat(output, 'g', -1, 64))
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,167 @@
+// analysis.iotable_test.go
+// tests of the input-output table, on a small economy of three industries
+
+package analysis
+
+import (
+	"capfront/models"
+	"math"
+	"strings"
+	"testing"
+)
+
+// A simulation (1) with means of production, labour power and consumption goods.
+// Department I and Department II each use both inputs; Idle uses means of production
+// but produces nothing. Simulation 2 has objects with the same ids, to check they are left out.
+var (
+	testCommodities = []models.Commodity{
+		{Id: 1, Simulation_id: 1, Name: "Means of Production", Usage: "PRODUCTIVE", Unit_Value: 2, Unit_Price: 3, Display_Order: 1},
+		{Id: 2, Simulation_id: 1, Name: LabourPower, Usage: "PRODUCTIVE", Unit_Value: 1, Unit_Price: 1, Display_Order: 2},
+		{Id: 3, Simulation_id: 1, Name: "Consumption Goods", Usage: "CONSUMPTION", Unit_Value: 1, Unit_Price: 1.5, Display_Order: 3},
+		{Id: 4, Simulation_id: 1, Name: "Money", Usage: "MONEY", Unit_Value: 1, Unit_Price: 1, Display_Order: 4},
+		{Id: 1, Simulation_id: 2, Name: "Other", Unit_Value: 100, Unit_Price: 100},
+	}
+	testIndustries = []models.Industry{
+		{Id: 11, Simulation_id: 1, Name: "Department II", Output_Scale: 50, Profit: 10},
+		{Id: 10, Simulation_id: 1, Name: "Department I", Output_Scale: 100, Profit: 20},
+		{Id: 12, Simulation_id: 1, Name: "Idle", Output_Scale: 0},
+		{Id: 20, Simulation_id: 2, Name: "Other Industry", Output_Scale: 1000, Profit: 1000},
+	}
+	testStocks = []models.Industry_Stock{
+		{Id: 1, Simulation_id: 1, Industry_id: 10, Commodity_id: 1, Usage_type: models.ProductionUsage, Requirement: 40, Value: 80},
+		{Id: 2, Simulation_id: 1, Industry_id: 10, Commodity_id: 2, Usage_type: models.ProductionUsage, Requirement: 20, Value: 20},
+		{Id: 3, Simulation_id: 1, Industry_id: 10, Commodity_id: 1, Usage_type: models.SalesUsage},
+		{Id: 4, Simulation_id: 1, Industry_id: 10, Commodity_id: 4, Usage_type: models.MoneyUsage, Size: 500, Value: 500},
+		{Id: 5, Simulation_id: 1, Industry_id: 11, Commodity_id: 1, Usage_type: models.ProductionUsage, Requirement: 30, Value: 60},
+		{Id: 6, Simulation_id: 1, Industry_id: 11, Commodity_id: 2, Usage_type: models.ProductionUsage, Requirement: 10, Value: 10},
+		{Id: 7, Simulation_id: 1, Industry_id: 11, Commodity_id: 3, Usage_type: models.SalesUsage},
+		{Id: 8, Simulation_id: 1, Industry_id: 12, Commodity_id: 1, Usage_type: models.ProductionUsage, Requirement: 5, Value: 10},
+		{Id: 9, Simulation_id: 1, Industry_id: 12, Commodity_id: 4, Usage_type: models.SalesUsage},
+		{Id: 10, Simulation_id: 2, Industry_id: 20, Commodity_id: 1, Usage_type: models.ProductionUsage, Requirement: 999, Value: 999},
+	}
+)
+
+// helper: true if a and b are equal to within rounding
+func approx(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func approxAll(a []float64, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !approx(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBuildIOTable(t *testing.T) {
+	tests := []struct {
+		mode         Mode
+		flows        [][]float64 // means of production, then labour power
+		totals       []float64
+		outputs      []float64
+		coefficients [][]float64
+	}{
+		{
+			mode:         Quantities,
+			flows:        [][]float64{{40, 30, 5}, {20, 10, 0}},
+			totals:       []float64{75, 30},
+			outputs:      []float64{100, 50, 0},
+			coefficients: [][]float64{{0.4, 0.6, 0}, {0.2, 0.2, 0}},
+		},
+		{
+			mode:         Values,
+			flows:        [][]float64{{80, 60, 10}, {20, 10, 0}},
+			totals:       []float64{150, 30},
+			outputs:      []float64{200, 50, 0},
+			coefficients: [][]float64{{0.4, 1.2, 0}, {0.1, 0.2, 0}},
+		},
+		{
+			mode:         Prices,
+			flows:        [][]float64{{120, 90, 15}, {20, 10, 0}},
+			totals:       []float64{225, 30},
+			outputs:      []float64{300, 75, 0},
+			coefficients: [][]float64{{0.4, 1.2, 0}, {20.0 / 300, 10.0 / 75, 0}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			table := BuildIOTable(test.mode, 1, testCommodities, testIndustries, testStocks)
+			if got := strings.Join(table.Industries, ","); got != "Department I,Department II,Idle" {
+				t.Errorf("industries are %s, want them in order of id and only those of the simulation", got)
+			}
+			if got := strings.Join(table.OutputCommodities, ","); got != "Means of Production,Consumption Goods,Money" {
+				t.Errorf("output commodities are %s", got)
+			}
+			if len(table.Rows) != 2 || table.Rows[0].Commodity != "Means of Production" || table.Rows[1].Commodity != LabourPower {
+				t.Fatalf("rows are %+v, want means of production then labour power", table.Rows)
+			}
+			for i, row := range table.Rows {
+				if !approxAll(row.Cells, test.flows[i]) || !approx(row.Total, test.totals[i]) {
+					t.Errorf("row %s is %v (total %g), want %v (total %g)", row.Commodity, row.Cells, row.Total, test.flows[i], test.totals[i])
+				}
+				if !approxAll(table.Coefficients[i].Cells, test.coefficients[i]) {
+					t.Errorf("coefficients of %s are %v, want %v", row.Commodity, table.Coefficients[i].Cells, test.coefficients[i])
+				}
+			}
+			if !approxAll(table.Outputs, test.outputs) {
+				t.Errorf("outputs are %v, want %v", table.Outputs, test.outputs)
+			}
+		})
+	}
+}
+
+// An industry that produces nothing has coefficients of zero, not infinities
+func TestIOTableZeroOutput(t *testing.T) {
+	table := BuildIOTable(Quantities, 1, testCommodities, testIndustries, testStocks)
+	for _, row := range table.Coefficients {
+		for col, cell := range row.Cells {
+			if math.IsInf(cell, 0) || math.IsNaN(cell) {
+				t.Errorf("coefficient of %s in %s is %g", row.Commodity, table.Industries[col], cell)
+			}
+		}
+	}
+	if empty := BuildIOTable(Quantities, 3, testCommodities, testIndustries, testStocks); len(empty.Industries) != 0 || len(empty.Rows) != 0 {
+		t.Errorf("a simulation with no objects gave %+v", empty)
+	}
+}
+
+func TestIOTableWriteCSV(t *testing.T) {
+	table := BuildIOTable(Quantities, 1, testCommodities, testIndustries, testStocks)
+	tests := []struct {
+		coefficients bool
+		want         string
+	}{
+		{false, "commodity,Department I,Department II,Idle,total\n" +
+			"Means of Production,40,30,5,75\n" +
+			"Labour Power,20,10,0,30\n" +
+			"output,100,50,0\n"},
+		{true, "commodity,Department I,Department II,Idle\n" +
+			"Means of Production,0.4,0.6,0\n" +
+			"Labour Power,0.2,0.2,0\n"},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		if err := table.WriteCSV(&out, test.coefficients); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.want {
+			t.Errorf("WriteCSV(coefficients=%v) wrote\n%s\nwant\n%s", test.coefficients, out.String(), test.want)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for input, want := range map[string]Mode{"": Quantities, "quantities": Quantities, "values": Values, "prices": Prices} {
+		if got, err := ParseMode(input); err != nil || got != want {
+			t.Errorf("ParseMode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseMode("weights"); err == nil {
+		t.Error("ParseMode accepted an unknown mode")
+	}
+}
@@ -0,0 +1,221 @@
+// analysis.moneyflow.go
+// works out where money went during an action (the M-C-M' circuit)
+// and lays the result out as a Sankey diagram.
+
+package analysis
+
+import (
+	"capfront/models"
+	"fmt"
+	"sort"
+)
+
+// Names of the pseudo-owners used when money is not conserved by an action
+const (
+	MoneyEnters = "(enters circulation)"
+	MoneyLeaves = "(leaves circulation)"
+)
+
+// Changes in money smaller than this are ignored
+const moneyEpsilon = 1e-6
+
+// An amount of money that passed from one owner to another
+type MoneyFlow struct {
+	From   string
+	To     string
+	Amount float64
+}
+
+// An owner of money (an industry or a class) and an amount: its balance, or how much it paid or received
+type moneyHolder struct {
+	name   string
+	amount float64
+}
+
+// Works out the flows of money between industries and classes during one action,
+// from the change in each owner's money stock.
+//
+// The server does not tell us who paid whom, so this is an approximation: the owners whose
+// money fell are matched with those whose money rose, largest first, until both are used up.
+// If the money lost does not equal the money gained, the difference is shown as entering
+// or leaving circulation.
+func MoneyFlows(before models.Snapshot, after models.Snapshot) []MoneyFlow {
+	start := moneyBalances(before)
+	end := moneyBalances(after)
+
+	var payers, receivers []moneyHolder
+	for key, balance := range end {
+		change := balance.amount - start[key].amount
+		switch {
+		case change < -moneyEpsilon:
+			payers = append(payers, moneyHolder{name: balance.name, amount: -change})
+		case change > moneyEpsilon:
+			receivers = append(receivers, moneyHolder{name: balance.name, amount: change})
+		}
+	}
+	for key, balance := range start { // owners who have disappeared take their money with them
+		if _, ok := end[key]; !ok && balance.amount > moneyEpsilon {
+			payers = append(payers, moneyHolder{name: balance.name, amount: balance.amount})
+		}
+	}
+	largestFirst(payers)
+	largestFirst(receivers)
+
+	var flows []MoneyFlow
+	p, r := 0, 0
+	for p < len(payers) && r < len(receivers) {
+		amount := min(payers[p].amount, receivers[r].amount)
+		flows = append(flows, MoneyFlow{From: payers[p].name, To: receivers[r].name, Amount: amount})
+		payers[p].amount -= amount
+		receivers[r].amount -= amount
+		if payers[p].amount <= moneyEpsilon {
+			p++
+		}
+		if receivers[r].amount <= moneyEpsilon {
+			r++
+		}
+	}
+	for ; p < len(payers); p++ {
+		flows = append(flows, MoneyFlow{From: payers[p].name, To: MoneyLeaves, Amount: payers[p].amount})
+	}
+	for ; r < len(receivers); r++ {
+		flows = append(flows, MoneyFlow{From: MoneyEnters, To: receivers[r].name, Amount: receivers[r].amount})
+	}
+	return flows
+}
+
+// helper function giving the value of the money stock of every industry and class in the snapshot.
+// The key distinguishes an industry from a class of the same name; the holder carries the name to display.
+func moneyBalances(s models.Snapshot) map[string]moneyHolder {
+	balances := make(map[string]moneyHolder)
+	industryNames := make(map[int]string)
+	for _, ind := range s.IndustryList {
+		if int(ind.Simulation_id) == s.Simulation {
+			industryNames[ind.Id] = ind.Name
+		}
+	}
+	classNames := make(map[int]string)
+	for _, class := range s.ClassList {
+		if int(class.Simulation_id) == s.Simulation {
+			classNames[class.Id] = class.Name
+		}
+	}
+	for _, stock := range s.IndustryStockList {
+		if name, ok := industryNames[stock.Industry_id]; ok && stock.Usage_type == models.MoneyUsage {
+			key := fmt.Sprintf("industry %d", stock.Industry_id)
+			balances[key] = moneyHolder{name: name, amount: balances[key].amount + float64(stock.Value)}
+		}
+	}
+	for _, stock := range s.ClassStockList {
+		if name, ok := classNames[stock.Class_id]; ok && stock.Usage_type == models.MoneyUsage {
+			key := fmt.Sprintf("class %d", stock.Class_id)
+			balances[key] = moneyHolder{name: name, amount: balances[key].amount + float64(stock.Value)}
+		}
+	}
+	return balances
+}
+
+// helper function sorting holders by amount, largest first, then by name
+func largestFirst(holders []moneyHolder) {
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].amount != holders[j].amount {
+			return holders[i].amount > holders[j].amount
+		}
+		return holders[i].name < holders[j].name
+	})
+}
+
+// A box in a Sankey diagram, representing one payer or one receiver
+type SankeyNode struct {
+	Name   string
+	Amount float64
+	X      float64
+	Y      float64
+	Height float64
+}
+
+// A band in a Sankey diagram, representing one flow
+type SankeyEdge struct {
+	MoneyFlow
+	Width float64
+	Path  string // an SVG path, from the right of the payer to the left of the receiver
+}
+
+// A Sankey diagram laid out and ready to draw as SVG
+type Sankey struct {
+	Width     float64
+	Height    float64
+	NodeWidth float64
+	Payers    []SankeyNode // on the left
+	Receivers []SankeyNode // on the right
+	Edges     []SankeyEdge
+}
+
+// Lays out flows as a Sankey diagram of the given size.
+// Payers are stacked on the left and receivers on the right, each as tall as the money
+// it paid or received; each flow is a band whose width is proportional to its amount.
+func LayoutSankey(flows []MoneyFlow, width float64, height float64) Sankey {
+	const gap = 10.0
+	const margin = 160.0 // room for the names beside the boxes
+	sankey := Sankey{Width: width, Height: height, NodeWidth: 12}
+
+	var payerNames, receiverNames []string
+	paid := make(map[string]float64)
+	received := make(map[string]float64)
+	total := 0.0
+	for _, f := range flows {
+		if _, ok := paid[f.From]; !ok {
+			payerNames = append(payerNames, f.From)
+		}
+		if _, ok := received[f.To]; !ok {
+			receiverNames = append(receiverNames, f.To)
+		}
+		paid[f.From] += f.Amount
+		received[f.To] += f.Amount
+		total += f.Amount
+	}
+	if total == 0 {
+		return sankey
+	}
+	spaces := float64(max(len(payerNames), len(receiverNames)) - 1)
+	scale := (height - gap*spaces) / total
+
+	payerAt := make(map[string]int)
+	y := 0.0
+	for i, name := range payerNames {
+		payerAt[name] = i
+		node := SankeyNode{Name: name, Amount: paid[name], X: margin, Y: y, Height: paid[name] * scale}
+		sankey.Payers = append(sankey.Payers, node)
+		y += node.Height + gap
+	}
+	receiverAt := make(map[string]int)
+	y = 0.0
+	for i, name := range receiverNames {
+		receiverAt[name] = i
+		node := SankeyNode{Name: name, Amount: received[name], X: width - margin - sankey.NodeWidth, Y: y, Height: received[name] * scale}
+		sankey.Receivers = append(sankey.Receivers, node)
+		y += node.Height + gap
+	}
+
+	// Each band leaves its payer, and arrives at its receiver, just below the previous one
+	payerUsed := make([]float64, len(payerNames))
+	receiverUsed := make([]float64, len(receiverNames))
+	for _, f := range flows {
+		from := sankey.Payers[payerAt[f.From]]
+		to := sankey.Receivers[receiverAt[f.To]]
+		w := f.Amount * scale
+		y0 := from.Y + payerUsed[payerAt[f.From]] + w/2
+		y1 := to.Y + receiverUsed[receiverAt[f.To]] + w/2
+		payerUsed[payerAt[f.From]] += w
+		receiverUsed[receiverAt[f.To]] += w
+		x0 := from.X + sankey.NodeWidth
+		x1 := to.X
+		middle := (x0 + x1) / 2
+		sankey.Edges = append(sankey.Edges, SankeyEdge{
+			MoneyFlow: f,
+			Width:     max(w, 1),
+			Path:      fmt.Sprintf("M %.1f %.1f C %.1f %.1f, %.1f %.1f, %.1f %.1f", x0, y0, middle, y0, middle, y1, x1, y1),
+		})
+	}
+	return sankey
+}
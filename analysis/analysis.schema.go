@@ -0,0 +1,140 @@
+// analysis.schema.go
+// arranges the industries of a simulation into Marx's reproduction schema
+// and checks whether the conditions of simple or expanded reproduction hold.
+
+package analysis
+
+import (
+	"capfront/models"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The name of the commodity whose stocks are variable capital.
+// The same botch as models.Industry.VariableCapital: there is no better way to recognise it yet.
+const LabourPower = "Labour Power"
+
+// Differences smaller than this fraction of the larger side are treated as equality,
+// since the server works in single precision.
+const Tolerance = 0.001
+
+// One department of the schema: constant capital, variable capital and surplus value,
+// added up over all the industries it contains
+type Department struct {
+	Name       string
+	Industries []string
+	C          float64
+	V          float64
+	S          float64
+}
+
+// The value of the department's product: c + v + s
+func (d Department) Total() float64 {
+	return d.C + d.V + d.S
+}
+
+// The reproduction schema of one period
+type Schema struct {
+	I          Department // produces means of production
+	II         Department // produces consumption goods
+	Unassigned []string   // industries whose output is neither; they are left out of the check
+	Supply     float64    // I(v+s): what Department I can exchange with Department II
+	Demand     float64    // IIc: the means of production Department II must replace
+	Simple     bool       // I(v+s) = IIc: the condition of simple reproduction
+	Expanded   bool       // I(v+s) > IIc: the condition of expanded reproduction
+}
+
+// Both departments, in order, for display
+func (s Schema) Departments() []Department {
+	return []Department{s.I, s.II}
+}
+
+// True if the schema satisfies either reproduction condition
+func (s Schema) Balanced() bool {
+	return s.Simple || s.Expanded
+}
+
+// Describes the state of the schema in a few words, for display
+func (s Schema) Verdict() string {
+	switch {
+	case s.Simple:
+		return "balanced: simple reproduction"
+	case s.Expanded:
+		return "balanced: expanded reproduction"
+	default:
+		return "unbalanced: Department I cannot replace the means of production used up by Department II"
+	}
+}
+
+// Builds the reproduction schema of one simulation, measured in values.
+//
+// Each industry goes into Department I or II according to the Usage of the commodity
+// it sells. For each industry, c is the value of its Production stocks other than
+// labour power, v is the value of its labour power, and s is its Profit.
+func BuildSchema(simulationID int, commodities []models.Commodity, industries []models.Industry, stocks []models.Industry_Stock) Schema {
+	schema := Schema{
+		I:  Department{Name: "Department I (means of production)"},
+		II: Department{Name: "Department II (consumption goods)"},
+	}
+
+	commodityByID := make(map[int]models.Commodity)
+	for _, c := range commodities {
+		if int(c.Simulation_id) == simulationID {
+			commodityByID[c.Id] = c
+		}
+	}
+
+	// Find out which department each industry belongs to, from what it sells
+	department := make(map[int]*Department)
+	for _, s := range stocks {
+		if s.Simulation_id == simulationID && s.Usage_type == models.SalesUsage {
+			switch strings.ToUpper(commodityByID[s.Commodity_id].Usage) {
+			case models.ProductiveCommodity: // means of production: Department I
+				department[s.Industry_id] = &schema.I
+			case models.ConsumptionCommodity: // consumption goods: Department II
+				department[s.Industry_id] = &schema.II
+			}
+		}
+	}
+
+	var members []models.Industry
+	for _, ind := range industries {
+		if int(ind.Simulation_id) == simulationID {
+			members = append(members, ind)
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].Id < members[j].Id })
+	for _, ind := range members {
+		d, ok := department[ind.Id]
+		if !ok {
+			schema.Unassigned = append(schema.Unassigned, ind.Name)
+			continue
+		}
+		d.Industries = append(d.Industries, ind.Name)
+		d.S += float64(ind.Profit)
+	}
+
+	for _, s := range stocks {
+		d, ok := department[s.Industry_id]
+		if !ok || s.Simulation_id != simulationID || s.Usage_type != models.ProductionUsage {
+			continue
+		}
+		if commodityByID[s.Commodity_id].Name == LabourPower {
+			d.V += float64(s.Value)
+		} else {
+			d.C += float64(s.Value)
+		}
+	}
+
+	schema.Supply = schema.I.V + schema.I.S
+	schema.Demand = schema.II.C
+	schema.Simple = nearlyEqual(schema.Supply, schema.Demand)
+	schema.Expanded = !schema.Simple && schema.Supply > schema.Demand
+	return schema
+}
+
+// helper function: true if a and b differ by less than Tolerance of the larger
+func nearlyEqual(a float64, b float64) bool {
+	return math.Abs(a-b) <= Tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
@@ -0,0 +1,90 @@
+// analysis.schema_test.go
+// tests of the reproduction schema, using the economy of analysis.iotable_test.go
+
+package analysis
+
+import (
+	"capfront/models"
+	"slices"
+	"testing"
+)
+
+func TestBuildSchemaDepartments(t *testing.T) {
+	schema := BuildSchema(1, testCommodities, testIndustries, testStocks)
+	tests := []struct {
+		department Department
+		industries []string
+		c, v, s    float64
+	}{
+		{schema.I, []string{"Department I"}, 80, 20, 20},
+		{schema.II, []string{"Department II"}, 60, 10, 10},
+	}
+	for _, test := range tests {
+		d := test.department
+		if !slices.Equal(d.Industries, test.industries) || !approx(d.C, test.c) || !approx(d.V, test.v) || !approx(d.S, test.s) {
+			t.Errorf("%s is %v c=%g v=%g s=%g, want %v c=%g v=%g s=%g",
+				d.Name, d.Industries, d.C, d.V, d.S, test.industries, test.c, test.v, test.s)
+		}
+	}
+	if !approx(schema.I.Total(), 120) {
+		t.Errorf("Department I's product is %g, want 120", schema.I.Total())
+	}
+	if !slices.Equal(schema.Unassigned, []string{"Idle"}) {
+		t.Errorf("unassigned industries are %v, want [Idle]", schema.Unassigned)
+	}
+}
+
+// The usage of a commodity is recognised whatever its case
+func TestBuildSchemaUsageCase(t *testing.T) {
+	commodities := slices.Clone(testCommodities)
+	commodities[0].Usage = "Productive"
+	commodities[2].Usage = "consumption"
+	schema := BuildSchema(1, commodities, testIndustries, testStocks)
+	if len(schema.I.Industries) != 1 || len(schema.II.Industries) != 1 {
+		t.Errorf("departments are %v and %v", schema.I.Industries, schema.II.Industries)
+	}
+}
+
+// Department I's profit decides I(v+s); IIc is 60 throughout
+func TestBuildSchemaConditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		profit   float32
+		simple   bool
+		expanded bool
+	}{
+		{"short", 20, false, false},
+		{"equal", 40, true, false},
+		{"equal within tolerance", 40.05, true, false},
+		{"just beyond tolerance", 40.1, false, true},
+		{"surplus", 50, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			industries := slices.Clone(testIndustries)
+			industries[1].Profit = test.profit
+			schema := BuildSchema(1, testCommodities, industries, testStocks)
+			if !approx(schema.Demand, 60) || !approx(schema.Supply, 20+float64(test.profit)) {
+				t.Errorf("supply %g and demand %g, want %g and 60", schema.Supply, schema.Demand, 20+float64(test.profit))
+			}
+			if schema.Simple != test.simple || schema.Expanded != test.expanded {
+				t.Errorf("simple %v and expanded %v, want %v and %v", schema.Simple, schema.Expanded, test.simple, test.expanded)
+			}
+			if schema.Balanced() != (test.simple || test.expanded) {
+				t.Errorf("Balanced() is %v", schema.Balanced())
+			}
+		})
+	}
+}
+
+// Objects of other simulations, and stocks that are not production stocks, are left out
+func TestBuildSchemaIgnoresOthers(t *testing.T) {
+	stocks := append(slices.Clone(testStocks),
+		models.Industry_Stock{Id: 11, Simulation_id: 2, Industry_id: 10, Commodity_id: 1, Usage_type: models.ProductionUsage, Value: 1000},
+		models.Industry_Stock{Id: 12, Simulation_id: 1, Industry_id: 10, Commodity_id: 1, Usage_type: models.SalesUsage, Value: 1000},
+	)
+	schema := BuildSchema(1, testCommodities, testIndustries, stocks)
+	if !approx(schema.I.C, 80) {
+		t.Errorf("Department I's constant capital is %g, want 80", schema.I.C)
+	}
+}
@@ -0,0 +1,38 @@
+// api.authoring.go
+// sends new templates, written by the admin, to the server
+
+package api
+
+import (
+	"capfront/auth"
+	"capfront/authoring"
+	"capfront/config"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Returned by UploadTemplate when no upload path has been configured
+var ErrUploadNotConfigured = errors.New("sending templates to the server is not configured (see CAPFRONT_TEMPLATE_UPLOAD_PATH)")
+
+// Reports whether templates can be sent to the server
+func CanUploadTemplates() bool {
+	return config.TemplateUploadPath != ""
+}
+
+// Sends a template definition to the server, using the admin's credentials,
+// and then reloads the templates so that users can clone the new one straight away.
+// The definition is sent to config.TemplateUploadPath, which must be set.
+// The definition should already have been validated; the server makes its own checks as well.
+func UploadTemplate(ctx context.Context, def authoring.Definition) error {
+	if !CanUploadTemplates() {
+		return ErrUploadNotConfigured
+	}
+	if _, err := auth.ProtectedJSONServerRequest(ctx, "admin", "create a template", config.TemplateUploadPath, def); err != nil {
+		return err
+	}
+	if err := Templates.Reload(); err != nil {
+		return fmt.Errorf("the template was created, but the list of templates could not be reloaded: %w", err)
+	}
+	return nil
+}
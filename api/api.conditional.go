@@ -0,0 +1,46 @@
+// api.conditional.go
+// remembers which version of each table we already have, so that
+// a refresh downloads and unmarshals only the tables that have changed.
+
+package api
+
+import (
+	"capfront/auth"
+	"crypto/sha256"
+	"sync"
+)
+
+// What we know about the version of one table that we hold
+type tableVersion struct {
+	validators auth.Validators // as supplied by the server, if it supplies them
+	hash       [sha256.Size]byte
+}
+
+// The version of each table we hold, by user and then by table name
+var versions = make(map[string]map[string]tableVersion)
+var versionsLock sync.Mutex
+
+// helper function giving the version we hold of a user's table, if any
+func versionOf(subject string, table string) tableVersion {
+	versionsLock.Lock()
+	defer versionsLock.Unlock()
+	return versions[subject][table]
+}
+
+// helper function recording the version of a table we have just unmarshalled
+func rememberVersion(subject string, table string, version tableVersion) {
+	versionsLock.Lock()
+	defer versionsLock.Unlock()
+	if versions[subject] == nil {
+		versions[subject] = make(map[string]tableVersion)
+	}
+	versions[subject][table] = version
+}
+
+// Forgets which versions of the user's tables we hold, so the next refresh downloads all of them.
+// Must be called whenever the user's tables are discarded.
+func ForgetVersions(subject string) {
+	versionsLock.Lock()
+	defer versionsLock.Unlock()
+	delete(versions, subject)
+}
@@ -5,12 +5,16 @@ package api
 
 import (
 	"capfront/auth"
+	"capfront/logging"
+	"capfront/metrics"
 	"capfront/models"
+	"capfront/persist"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
-	"fmt"
-	"log"
-
-	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
 )
 
 // Contains the information needed to fetch data for one model from the remote server
@@ -36,77 +40,220 @@ var ApiList = [9]ApiItem{
 	{`trace`, `trace/`},
 }
 
+// Counts the refreshes in progress, so that shutdown can wait for them.
+// Once shutdown has begun (refreshesStopped), no refresh may start, so that
+// nothing is added to the count while WaitForRefreshes waits for it.
+var refreshes sync.WaitGroup
+var refreshesLock sync.Mutex
+var refreshesStopped bool
+
+// helper function that counts a refresh in, unless shutdown has begun.
+// Returns false, and counts nothing, if it has.
+func startRefresh() bool {
+	refreshesLock.Lock()
+	defer refreshesLock.Unlock()
+	if refreshesStopped {
+		return false
+	}
+	refreshes.Add(1)
+	return true
+}
+
 // Iterates through ApiList to refresh all objects owned by the user
-// from the remote server, by invoking FetchAPI.
+// from the remote server, by invoking fetchTable.
+// Tables that have not changed since the last refresh are left alone.
+// The shared lists (templates and users) are not fetched: see api.shared.go.
 // returns False if any table failed.
 // returns True if all tables succeeded.
-// TODO ctx is not needed.
-func Refresh(ctx *gin.Context, username string) bool {
-	for i := range ApiList {
+// ctx is the context of the request that asked for the refresh; the request ID it carries is sent to the server.
+func Refresh(ctx context.Context, username string) bool {
+	if !startRefresh() {
+		logging.Warn("Refresh refused because we are shutting down", "user", username)
+		return false
+	}
+	defer refreshes.Done()
+	user, ok := models.LookupUser(username)
+	if !ok {
+		logging.Warn("Cannot refresh tables for non-existent user", "user", username)
+		metrics.ObserveRefresh(false)
+		return false
+	}
+	updated := 0
+	for i := sharedTables; i < len(ApiList); i++ {
 		a := ApiList[i]
-		if !FetchAPI(&a, username) {
+		changed, ok := fetchTable(ctx, &a, username, username, user, true)
+		if !ok {
 			// If one fetch fails, there is no point continuing because
 			// there has been a login failure or the server is down.
 			// TODO handle this so the caller knows something went wrong.
-			fmt.Println("Cannot refresh from remote server; giving up")
+			logging.Warn("Cannot refresh from remote server; giving up", "user", username, "table", a.Name)
+			metrics.ObserveRefresh(false)
+			return false
+		}
+		if changed {
+			updated++
+		}
+	}
+	logging.Debug("Refresh complete", "user", username, "updated", updated, "tables", len(ApiList)-sharedTables)
+	metrics.ObserveRefresh(true)
+	metrics.ObserveRefreshTables(updated, len(ApiList)-sharedTables-updated)
+	persist.SaveUser(user)
+	return true
+}
+
+// Fetches the tables belonging to the user 'subject', using the credentials of 'username',
+// into the tables of 'into'. The subject's own record is not touched.
+// This lets the admin look at another user's simulation, and Resync fetch a user's tables afresh.
+// Every table is downloaded, since into is not the record whose versions we remember.
+// The shared lists (templates and users) are not fetched, because they are not the subject's.
+// returns False if any table failed.
+func RefreshAs(ctx context.Context, username string, subject string, into *models.UserData) bool {
+	if !startRefresh() {
+		logging.Warn("Refresh refused because we are shutting down", "user", username, "subject", subject)
+		return false
+	}
+	defer refreshes.Done()
+	for i := sharedTables; i < len(ApiList); i++ {
+		a := ApiList[i]
+		if _, ok := fetchTable(ctx, &a, username, subject, into, false); !ok {
+			logging.Warn("Cannot refresh tables on behalf of another user; giving up", "user", username, "subject", subject, "table", a.Name)
 			return false
 		}
 	}
-	log.Output(1, "Refresh complete")
+	logging.Debug("Refresh on behalf of another user complete", "user", username, "subject", subject, "tables", len(ApiList)-sharedTables)
 	return true
 }
 
 // fetch the data specified by item for user.
 // if we got something, return true.
 // if not, for whatever reason, return false.
-func FetchAPI(item *ApiItem, username string) (result bool) {
-	body, _ := auth.ProtectedResourceServerRequest(username, "Fetch Table", item.ApiUrl)
+func FetchAPI(ctx context.Context, item *ApiItem, username string) (result bool) {
+	user, ok := models.LookupUser(username)
+	if !ok {
+		return false
+	}
+	_, result = fetchTable(ctx, item, username, username, user, true)
+	return result
+}
+
+// fetch the data specified by item, belonging to subject, using the credentials of username.
+// The result is stored in the tables of into, which may be nil if item is one of the shared lists.
+// If conditional, the request is conditional: if the server says the table has not changed, or sends
+// exactly what it sent last time, nothing is unmarshalled and changed is false.
+// This relies on into holding the version of subject's table that we remember,
+// so into must be subject's own record (or nil).
+func fetchTable(ctx context.Context, item *ApiItem, username string, subject string, into *models.UserData, conditional bool) (changed bool, ok bool) {
+	if into == nil && item.Name != `template` && item.Name != `users` {
+		logging.Error("Nowhere to store table", "table", item.Name, "subject", subject)
+		return false, false
+	}
+	var held tableVersion
+	if conditional {
+		held = versionOf(subject, item.Name)
+	}
+	body, validators, notModified, err := auth.ConditionalServerRequestAs(ctx, username, subject, "Fetch Table", item.ApiUrl, held.validators)
+	if err != nil {
+		return false, false
+	}
+	if notModified {
+		logging.Debug("Table not modified", "table", item.Name, "subject", subject)
+		return false, true
+	}
 	// Check for an empty result.
 	// This can happen, but we need to know it did.
 	if string(body) == `[]` {
-		fmt.Println("The result was an empty table")
-		return false
+		logging.Debug("The result was an empty table", "table", item.Name, "subject", subject)
+		return false, false
+	}
+	hash := sha256.Sum256(body)
+	if conditional && hash == held.hash {
+		logging.Debug("Table unchanged", "table", item.Name, "subject", subject)
+		rememberVersion(subject, item.Name, tableVersion{validators: validators, hash: hash})
+		return false, true
 	}
 	var jsonErr error
 	switch item.Name {
 	case `template`:
-		jsonErr = json.Unmarshal(body, &models.TemplateList)
+		var templates []models.Simulation
+		if jsonErr = json.Unmarshal(body, &templates); jsonErr == nil {
+			models.SetTemplates(templates)
+		}
 	case `users`:
-		jsonErr = json.Unmarshal(body, &models.AdminUserList)
+		var users []models.UserData
+		if jsonErr = json.Unmarshal(body, &users); jsonErr == nil {
+			models.SetAdminUsers(users)
+		}
 	case `simulation`:
-		jsonErr = json.Unmarshal(body, &models.Users[username].SimulationList)
+		jsonErr = json.Unmarshal(body, &into.SimulationList)
 	case `commodity`:
-		jsonErr = json.Unmarshal(body, &models.Users[username].CommodityList)
+		jsonErr = json.Unmarshal(body, &into.CommodityList)
 	case `industry`:
-		jsonErr = json.Unmarshal(body, &models.Users[username].IndustryList)
+		jsonErr = json.Unmarshal(body, &into.IndustryList)
 	case `class`:
-		jsonErr = json.Unmarshal(body, &models.Users[username].ClassList)
+		jsonErr = json.Unmarshal(body, &into.ClassList)
 	case `industry_stock`:
-		jsonErr = json.Unmarshal(body, &models.Users[username].IndustryStockList)
+		jsonErr = json.Unmarshal(body, &into.IndustryStockList)
 	case `class_stock`:
-		jsonErr = json.Unmarshal(body, &models.Users[username].ClassStockList)
+		jsonErr = json.Unmarshal(body, &into.ClassStockList)
 	case `trace`:
-		jsonErr = json.Unmarshal(body, &models.Users[username].TraceList)
+		jsonErr = json.Unmarshal(body, &into.TraceList)
 	default:
-		log.Output(1, fmt.Sprintf("Unknown dataset%s ", item.Name))
+		logging.Error("Unknown dataset", "table", item.Name)
 	}
 	if jsonErr != nil {
-		log.Output(1, fmt.Sprintf("Failed to unmarshal template json because: %s", jsonErr))
-		return false
+		logging.Error("Failed to unmarshal table", "table", item.Name, "error", jsonErr)
+		return false, false
+	}
+	if into != nil {
+		// What the local engine did must survive the server's older copy
+		into.ApplyLocalRuns()
 	}
 
-	// uncomment for verbose diagnostics
-	// fmt.Println("After loading, the models map for user guest is:")
-	// PrintUsers()
-	return true
+	if conditional {
+		rememberVersion(subject, item.Name, tableVersion{validators: validators, hash: hash})
+	}
+	return true, true
 }
 
 // diagnostic helper function
 func PrintUsers() {
-	b, err := json.MarshalIndent(models.Users, " ", " ")
+	b, err := json.MarshalIndent(models.AllUsers(), " ", " ")
 	if err != nil {
-		fmt.Println("Could not marshal the Users object")
+		logging.Error("Could not marshal the Users object", "error", err)
 		return
 	}
-	fmt.Println(string(b))
+	logging.Debug("Users", "contents", string(b))
+}
+
+// Checks whether the backend is reachable, waiting at most timeout for it to answer.
+// Any response at all, even an error status, counts as reachable.
+func Ping(timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Get(auth.APISOURCE)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Waits for any refreshes in progress to finish, but for no longer than timeout.
+// No refresh may start once this has been called.
+// Returns false if they did not finish in time.
+func WaitForRefreshes(timeout time.Duration) bool {
+	refreshesLock.Lock()
+	refreshesStopped = true
+	refreshesLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		refreshes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
 }
@@ -0,0 +1,171 @@
+// api.fetch_test.go
+// tests of the conditional refresh, against a fake server
+
+package api
+
+import (
+	"capfront/auth"
+	"capfront/metrics"
+	"capfront/models"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A fake backend serving one user's tables.
+// If useETags, it sends an ETag with each table and answers 304 when the
+// client already has that version; otherwise it sends the whole table every time.
+type fakeBackend struct {
+	mu             sync.Mutex
+	bodies         map[string]string // by path, without the leading slash
+	useETags       bool
+	requests       int
+	notModified304 int
+}
+
+func newFakeBackend() *fakeBackend {
+	bodies := make(map[string]string)
+	for i := sharedTables; i < len(ApiList); i++ {
+		bodies[ApiList[i].ApiUrl] = fmt.Sprintf(`[{"id":%d}]`, i)
+	}
+	return &fakeBackend{bodies: bodies}
+}
+
+func (f *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	body, ok := f.bodies[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if f.useETags {
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(body)))
+		if r.Header.Get("If-None-Match") == etag {
+			f.notModified304++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+	}
+	w.Write([]byte(body))
+}
+
+// helper that sets the body of one table
+func (f *fakeBackend) set(path string, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bodies[path] = body
+}
+
+// helper that stops the backend serving one table
+func (f *fakeBackend) remove(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.bodies, path)
+}
+
+// helper that returns how many requests, and how many 304s, the backend has served, and resets the counts
+func (f *fakeBackend) counts() (requests int, notModified int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests, notModified = f.requests, f.notModified304
+	f.requests, f.notModified304 = 0, 0
+	return requests, notModified
+}
+
+// Points the auth helpers at a fake backend and creates a user to refresh.
+// Everything is put back when the test ends.
+func startFakeBackend(t *testing.T, username string) *fakeBackend {
+	backend := newFakeBackend()
+	server := httptest.NewServer(backend)
+	oldSource := auth.APISOURCE
+	auth.APISOURCE = server.URL + "/"
+	models.AddUser(&models.UserData{UserName: username, LoggedIn: true, Token: "token"})
+	t.Cleanup(func() {
+		server.Close()
+		auth.APISOURCE = oldSource
+		ForgetVersions(username)
+	})
+	return backend
+}
+
+// helper that refreshes the user and returns how many tables were updated and left unchanged
+func refreshCounting(t *testing.T, username string) (updated int, unchanged int) {
+	beforeUpdated := metrics.RefreshTables.Value("updated")
+	beforeUnchanged := metrics.RefreshTables.Value("unchanged")
+	if !Refresh(context.Background(), username) {
+		t.Fatal("Refresh failed")
+	}
+	return int(metrics.RefreshTables.Value("updated") - beforeUpdated),
+		int(metrics.RefreshTables.Value("unchanged") - beforeUnchanged)
+}
+
+func TestRefreshConditional(t *testing.T) {
+	tables := len(ApiList) - sharedTables
+	tests := []struct {
+		name            string
+		useETags        bool
+		change          string // path of a table whose body changes before the second refresh, if any
+		wantUpdated     int
+		wantNotModified int
+	}{
+		{"server answers 304", true, "", 0, tables},
+		{"server resends the same tables", false, "", 0, 0},
+		{"one table changed, with ETags", true, "commodities/", 1, tables - 1},
+		{"one table changed, without ETags", false, "industries/", 1, 0},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			username := fmt.Sprintf("conditional%d", i)
+			backend := startFakeBackend(t, username)
+			backend.useETags = test.useETags
+
+			if updated, unchanged := refreshCounting(t, username); updated != tables || unchanged != 0 {
+				t.Fatalf("first refresh: %d updated and %d unchanged, want %d and 0", updated, unchanged, tables)
+			}
+			backend.counts()
+
+			if test.change != "" {
+				backend.set(test.change, `[{"id":99},{"id":100}]`)
+			}
+			updated, unchanged := refreshCounting(t, username)
+			if updated != test.wantUpdated || unchanged != tables-test.wantUpdated {
+				t.Errorf("second refresh: %d updated and %d unchanged, want %d and %d", updated, unchanged, test.wantUpdated, tables-test.wantUpdated)
+			}
+			requests, notModified := backend.counts()
+			if requests != tables || notModified != test.wantNotModified {
+				t.Errorf("second refresh: %d requests and %d 304s, want %d and %d", requests, notModified, tables, test.wantNotModified)
+			}
+
+			user := models.User(username)
+			if len(user.SimulationList) != 1 || len(user.TraceList) != 1 {
+				t.Errorf("unchanged tables were not kept: %d simulations and %d trace entries", len(user.SimulationList), len(user.TraceList))
+			}
+			switch test.change {
+			case "commodities/":
+				if len(user.CommodityList) != 2 {
+					t.Errorf("the changed commodity table has %d rows, want 2", len(user.CommodityList))
+				}
+			case "industries/":
+				if len(user.IndustryList) != 2 {
+					t.Errorf("the changed industry table has %d rows, want 2", len(user.IndustryList))
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshFailsOnServerError(t *testing.T) {
+	backend := startFakeBackend(t, "conditionalfail")
+	backend.remove("classes/")
+	if Refresh(context.Background(), "conditionalfail") {
+		t.Error("Refresh succeeded although the server could not supply the classes")
+	}
+}
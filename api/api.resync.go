@@ -0,0 +1,139 @@
+// api.resync.go
+// brings a user's tables back into line with the server, for example
+// after the server has restarted or been reset since the user last logged in.
+
+package api
+
+import (
+	"capfront/auth"
+	"capfront/logging"
+	"capfront/models"
+	"capfront/persist"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// A simulation whose state changed during a resync
+type StateChange struct {
+	Id     int
+	Name   string
+	Before string
+	After  string
+}
+
+// What changed when one user was resynchronised with the server
+type ResyncReport struct {
+	UserName      string
+	Added         []models.Simulation // simulations the server has but we did not
+	Removed       []models.Simulation // simulations we had but the server no longer has
+	Changed       []StateChange       // simulations whose state is not what we thought
+	CurrentBefore int                 // the current simulation before the resync
+	CurrentAfter  int                 // ...and after
+	Err           error               // nil if the resync succeeded
+}
+
+// True if the resync succeeded and found nothing out of date
+func (r ResyncReport) Unchanged() bool {
+	return r.Err == nil && len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Changed) == 0 && r.CurrentBefore == r.CurrentAfter
+}
+
+// Re-fetches the user's details, simulation list and all tables from the server,
+// discarding everything we held before (including the trace, and whatever the local
+// engine did), so that nothing stale survives.
+// The tables are fetched into a fresh record and replace the user's only if all of them arrive,
+// so a failed resync leaves the user with what they had.
+// Reports what changed.
+func Resync(ctx context.Context, username string) ResyncReport {
+	report := ResyncReport{UserName: username}
+	user, ok := models.LookupUser(username)
+	if !ok {
+		report.Err = fmt.Errorf("we have no record of user %s", username)
+		return report
+	}
+	report.CurrentBefore = user.CurrentSimulation
+	report.CurrentAfter = user.CurrentSimulation
+	before := user.SimulationList
+
+	body, err := auth.ProtectedResourceServerRequest(ctx, username, "resynchronise with server", `users/`+username)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	var serverItem models.UserServerData
+	if err := json.Unmarshal(body, &serverItem); err != nil {
+		report.Err = fmt.Errorf("could not understand the server's details of user %s: %w", username, err)
+		return report
+	}
+	if !serverItem.Is_logged_in {
+		report.Err = errors.New("the server no longer accepts that this user is logged in")
+		return report
+	}
+
+	fresh := models.UserData{UserName: username}
+	if !RefreshAs(ctx, username, username, &fresh) {
+		report.Err = errors.New("the server did not supply all the tables")
+		return report
+	}
+
+	user.CurrentSimulation = serverItem.CurrentSimulation
+	report.CurrentAfter = serverItem.CurrentSimulation
+	user.SimulationList = fresh.SimulationList
+	user.CommodityList = fresh.CommodityList
+	user.IndustryList = fresh.IndustryList
+	user.ClassList = fresh.ClassList
+	user.IndustryStockList = fresh.IndustryStockList
+	user.ClassStockList = fresh.ClassStockList
+	user.TraceList = fresh.TraceList
+	user.History = nil
+	user.LocalRuns = nil
+	user.UnseenAction = false
+	ForgetVersions(username) // the versions we remember are of the tables we have just replaced
+	persist.SaveUser(user)
+
+	compareSimulations(&report, before, user.SimulationList)
+	logging.Info("Resynchronised user with server", "user", username,
+		"added", len(report.Added), "removed", len(report.Removed), "changed", len(report.Changed))
+	return report
+}
+
+// Resynchronises every user who is logged in, in order of name.
+// Used by the admin, for example after resetting the database.
+// Each user's data is locked with lock, which returns the function that unlocks it,
+// while it is checked and resynchronised.
+func ResyncAll(ctx context.Context, lock func(username string) (unlock func())) []ResyncReport {
+	reports := []ResyncReport{}
+	for _, user := range models.AllUsers() {
+		unlock := lock(user.UserName)
+		if user.LoggedIn && user.Token != "" {
+			reports = append(reports, Resync(ctx, user.UserName))
+		}
+		unlock()
+	}
+	return reports
+}
+
+// helper function to compare the simulation lists before and after a resync
+func compareSimulations(report *ResyncReport, before []models.Simulation, after []models.Simulation) {
+	previous := make(map[int]models.Simulation, len(before))
+	for _, s := range before {
+		previous[s.Id] = s
+	}
+	for _, s := range after {
+		old, ok := previous[s.Id]
+		if !ok {
+			report.Added = append(report.Added, s)
+			continue
+		}
+		if old.State != s.State {
+			report.Changed = append(report.Changed, StateChange{Id: s.Id, Name: s.Name, Before: old.State, After: s.State})
+		}
+		delete(previous, s.Id)
+	}
+	for _, s := range before {
+		if _, gone := previous[s.Id]; gone {
+			report.Removed = append(report.Removed, s)
+		}
+	}
+}
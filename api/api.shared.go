@@ -0,0 +1,51 @@
+// api.shared.go
+// data that is shared between all users, rather than belonging to any one of them.
+// It is fetched with the admin's credentials and kept in caches, so that
+// it is reloaded from time to time and new templates or users appear without a restart.
+
+package api
+
+import (
+	"capfront/cache"
+	"capfront/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+// ApiList[:sharedTables] are shared by all users. They are fetched through the caches below,
+// not by Refresh.
+const sharedTables = 2
+
+// How long shared data is kept before it is reloaded, unless main changes it
+const defaultSharedTTL = 5 * time.Minute
+
+// The templates from which users create simulations
+var Templates = cache.New("templates", defaultSharedTTL, loadTemplates)
+
+// The users known to the server
+var AdminUsers = cache.New("users", defaultSharedTTL, loadAdminUsers)
+
+// helper function to load the templates into models.Templates.
+// Returns a copy, so that what the cache hands out is not changed by the next reload.
+func loadTemplates() ([]models.Simulation, error) {
+	if _, ok := fetchTable(context.Background(), &ApiList[0], "admin", "admin", nil, true); !ok {
+		return nil, fmt.Errorf("could not fetch the templates")
+	}
+	return models.Templates(), nil
+}
+
+// helper function to load the users into models.AdminUsers.
+// Any user we have not heard of (for example, one who registered through another frontend)
+// is added to the list of users, so that they can log in here.
+func loadAdminUsers() ([]models.UserData, error) {
+	if _, ok := fetchTable(context.Background(), &ApiList[1], "admin", "admin", nil, true); !ok {
+		return nil, fmt.Errorf("could not fetch the users")
+	}
+	list := models.AdminUsers()
+	for _, item := range list {
+		// don't clobber users we already know, including the admin
+		models.AddUserIfAbsent(&models.UserData{LoggedIn: false, UserName: item.UserName, Token: ""})
+	}
+	return list, nil
+}
@@ -0,0 +1,48 @@
+// assets.go
+// templates and static files, embedded in the binary so that it can run from anywhere
+
+package main
+
+import (
+	"capfront/display"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+//go:embed static
+var embeddedStatic embed.FS
+
+// Loads the templates and serves the static files under /static.
+// Normally both come from the files embedded in the binary, and static files
+// may be cached by browsers for a day.
+// In dev mode both come from disk (so run from the repo root), templates are
+// re-read on every request so that edits show at once, and nothing is cached.
+func loadAssets(r *gin.Engine, dev bool) {
+	var static fs.FS
+	cacheControl := "public, max-age=86400"
+	r.SetFuncMap(display.TemplateFuncs())
+	if dev {
+		// gin re-reads the templates on every render when it is in debug mode
+		r.LoadHTMLGlob("./templates/**/*")
+		static = os.DirFS("static")
+		cacheControl = "no-cache"
+	} else {
+		t := template.Must(template.New("").Funcs(display.TemplateFuncs()).ParseFS(embeddedTemplates, "templates/*/*.html"))
+		r.SetHTMLTemplate(t)
+		static, _ = fs.Sub(embeddedStatic, "static")
+	}
+	display.StaticFiles = static
+
+	staticGroup := r.Group("/static", func(ctx *gin.Context) {
+		ctx.Header("Cache-Control", cacheControl)
+	})
+	staticGroup.StaticFS("/", http.FS(static))
+}
@@ -0,0 +1,241 @@
+// audit.trail.go
+// an append-only record of who did what: actions, simulations created and deleted,
+// logins, logouts and admin operations.
+// The record is a JSON Lines file, one Entry per line, which is rotated when it gets too big.
+
+package audit
+
+import (
+	"bufio"
+	"capfront/logging"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One line of the audit trail
+type Entry struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Simulation int       `json:"simulation"`
+	Action     string    `json:"action"`
+	Outcome    string    `json:"outcome"`    // "success", "failure", or a short reason such as "throttled"
+	LatencyMs  int64     `json:"latency_ms"` // time the backend took to respond, if it was asked
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// Outcomes recorded in the trail
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// The audit trail file and its rotation policy
+type Trail struct {
+	Path     string // the file currently being written
+	MaxBytes int64  // rotate when the file would grow beyond this
+	Backups  int    // number of rotated files kept, named Path.1, Path.2, ...
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+}
+
+// The trail used by the handlers. Nil until Open is called, in which case
+// entries are only written to the log.
+var Log *Trail
+
+// Opens (or creates) the audit trail at path, and its directory, and makes it the one used by Record.
+func Open(path string, maxBytes int64, backups int) error {
+	t := &Trail{Path: path, MaxBytes: maxBytes, Backups: backups}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("could not create the directory of audit trail %s: %w", path, err)
+	}
+	if err := t.open(); err != nil {
+		return err
+	}
+	Log = t
+	return nil
+}
+
+// helper function to open the current file for appending
+func (t *Trail) open() error {
+	f, err := os.OpenFile(t.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("could not open audit trail %s: %w", t.Path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	t.file = f
+	t.size = info.Size()
+	return nil
+}
+
+// Appends e to the trail, rotating the file first if it is full.
+func (t *Trail) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return fmt.Errorf("audit trail %s is closed", t.Path)
+	}
+	if t.MaxBytes > 0 && t.size+int64(len(line)) > t.MaxBytes {
+		if err := t.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := t.file.Write(line)
+	t.size += int64(n)
+	return err
+}
+
+// helper function to move Path to Path.1, Path.1 to Path.2 and so on,
+// discarding the oldest, and then start a new file.
+// Must be called with the lock held.
+func (t *Trail) rotate() error {
+	t.file.Close()
+	t.file = nil
+	for i := t.Backups - 1; i >= 1; i-- {
+		os.Rename(t.backupName(i), t.backupName(i+1))
+	}
+	if t.Backups > 0 {
+		os.Rename(t.Path, t.backupName(1))
+	} else {
+		os.Remove(t.Path)
+	}
+	return t.open()
+}
+
+// helper function giving the name of the i'th rotated file
+func (t *Trail) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", t.Path, i)
+}
+
+// Flushes the trail to disk and closes it.
+func (t *Trail) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	t.file.Sync()
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// Selects entries from the trail.
+// Empty fields match everything. Text is matched, ignoring case, against every field.
+type Filter struct {
+	User    string
+	Action  string
+	Outcome string
+	Text    string
+}
+
+// helper function to decide whether e passes the filter
+func (f Filter) matches(e Entry) bool {
+	if f.User != "" && e.User != f.User {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.Outcome != "" && e.Outcome != f.Outcome {
+		return false
+	}
+	if f.Text != "" {
+		haystack := strings.ToLower(fmt.Sprintf("%s %d %s %s %s", e.User, e.Simulation, e.Action, e.Outcome, e.Detail))
+		if !strings.Contains(haystack, strings.ToLower(f.Text)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the entries, in the files of the trail, that pass filter.
+// The most recent come first; at most limit are returned.
+// The files are read newest first, and reading stops at the end of the file in which limit
+// is reached, since every entry in the older files is older still.
+// The files are read without the lock, so that searching does not hold up Write; if the trail
+// is rotated during a search, an entry may be missed or appear twice.
+func (t *Trail) Search(filter Filter, limit int) ([]Entry, error) {
+	var result []Entry
+	files := []string{t.Path}
+	for i := 1; i <= t.Backups; i++ {
+		files = append(files, t.backupName(i))
+	}
+	for _, name := range files {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e Entry
+			if json.Unmarshal(scanner.Bytes(), &e) != nil {
+				continue // skip anything damaged rather than lose the rest
+			}
+			if filter.matches(e) {
+				result = append(result, e)
+			}
+		}
+		f.Close()
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.After(result[j].Time) })
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// Records an event in the audit trail used by the handlers.
+// err is the error, if any, that the event produced; it decides the outcome.
+// latency is how long the backend took, or zero if it was not asked.
+func Record(user string, simulation int, action string, err error, latency time.Duration, detail string) {
+	e := Entry{
+		Time:       time.Now().UTC(),
+		User:       user,
+		Simulation: simulation,
+		Action:     action,
+		Outcome:    Success,
+		LatencyMs:  latency.Milliseconds(),
+		Detail:     detail,
+	}
+	if err != nil {
+		e.Outcome = Failure
+		if e.Detail == "" {
+			e.Detail = err.Error()
+		}
+	}
+	RecordEntry(e)
+}
+
+// Records a fully-specified entry in the audit trail used by the handlers.
+func RecordEntry(e Entry) {
+	logging.Info("audit", "user", e.User, "simulation", e.Simulation, "action", e.Action, "outcome", e.Outcome, "latency_ms", e.LatencyMs)
+	if Log == nil {
+		return
+	}
+	if err := Log.Write(e); err != nil {
+		logging.Error("Could not write to audit trail", "error", err)
+	}
+}
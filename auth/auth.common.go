@@ -5,12 +5,17 @@ package auth
 
 import (
 	"bytes"
+	"capfront/logging"
+	"capfront/metrics"
 	"capfront/models"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -41,67 +46,210 @@ func Get_current_user(ctx *gin.Context) (string, error) {
 }
 
 // Helper function to prepare and send a request for a protected service to the server
-// ctx is the context of a handler
+// ctx is the context of the request being handled; the request ID it carries, if any, is sent to the server
 // username is the name of the user requesting the service
 // description is a user-friendly name for the action being requested, which is used to produce error messages
 // relativePath is appended to the URL of the remote server and tells the server what we want it to do
-func ProtectedResourceServerRequest(username string, description string, relativePath string) ([]byte, error) {
-	user, ok := models.Users[username]
+func ProtectedResourceServerRequest(ctx context.Context, username string, description string, relativePath string) ([]byte, error) {
+	return ProtectedResourceServerRequestAs(ctx, username, username, description, relativePath)
+}
+
+// As ProtectedResourceServerRequest, but asks for the resources of the user 'subject'
+// using the credentials of the user 'username'.
+// Used by the admin to view another user's simulation without knowing their password.
+func ProtectedResourceServerRequestAs(ctx context.Context, username string, subject string, description string, relativePath string) ([]byte, error) {
+	body, _, _, err := ConditionalServerRequestAs(ctx, username, subject, description, relativePath, Validators{})
+	return body, err
+}
+
+// What the server told us, last time, to identify the version of a resource it sent.
+// Sent back with the next request, so the server can say the resource has not changed.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// As ProtectedResourceServerRequestAs, but sends the validators in cached (if any) with the request.
+// If the server replies 304 Not Modified, returns no body and notModified true,
+// and the caller should keep what it already has.
+// Otherwise returns the body with the validators the server sent with it.
+func ConditionalServerRequestAs(ctx context.Context, username string, subject string, description string, relativePath string, cached Validators) (body []byte, validators Validators, notModified bool, err error) {
+	user, ok := models.LookupUser(username)
 
 	if !ok {
-		log.Output(1, fmt.Sprintf("Attempt to access the server by non-existent user %s", username))
-		return nil, fmt.Errorf("user %s tried to access the server, but we don't have any record of that user", username)
+		logging.Warn("Attempt to access the server by non-existent user", "user", username)
+		return nil, cached, false, fmt.Errorf("user %s tried to access the server, but we don't have any record of that user", username)
 	}
 
+	// Nothing is written to user: this is also called in the background, for example to reload the templates
 	accessToken := user.Token
-	userMessage := models.UserMessage{StatusCode: http.StatusTeapot, Message: ""} // Couldn't resist it
-	user.UserMessage = &userMessage
+	requestID := logging.RequestIDFrom(ctx)
 	url := APISOURCE + relativePath
-	log.Output(1, fmt.Sprintf("User %s asked URL %s for resource %s \n", username, relativePath, description))
+	logging.Debug("Server request", "user", username, "subject", subject, "path", relativePath, "description", description, "requestid", requestID)
 
-	body, _ := json.Marshal(models.RequestData{User: username}) // Wrap username in RequestData struct to prepare for unmarshal
-	resp, err := http.NewRequest("GET", url, bytes.NewBuffer(body))
+	requestBody, _ := json.Marshal(models.RequestData{User: subject}) // Wrap username in RequestData struct to prepare for unmarshal
+	resp, err := http.NewRequest("GET", url, bytes.NewBuffer(requestBody))
 	if err != nil {
-		log.Output(1, fmt.Sprintf("Error %v for user %s from URL %s for resource %s \n", err, username, url, description))
-		userMessage.StatusCode = http.StatusBadRequest
-		userMessage.Message = fmt.Sprintf("Server error %v ", err)
-		return nil, err
+		logging.Error("Could not build server request", "user", username, "url", url, "description", description, "error", err)
+		return nil, cached, false, err
 	}
 
 	resp.Header.Add("Content-Type", "application/json")
 	resp.Header.Set("User-Agent", "Capitalism reader")
 	resp.Header.Add("Authorization", "Bearer "+accessToken)
+	if requestID != "" {
+		resp.Header.Set(logging.RequestIDHeader, requestID)
+	}
+	if cached.ETag != "" {
+		resp.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		resp.Header.Set("If-Modified-Since", cached.LastModified)
+	}
 
 	client := &http.Client{Timeout: time.Second * 2} // Timeout after 2 seconds
-	res, _ := client.Do(resp)
+	started := time.Now()
+	res, err := client.Do(resp)
+	metrics.ObserveBackend(relativePath, started, err, statusOf(res))
 	if res == nil {
 		// Server failure
 		// TODO display nice error screen
-		log.Output(1, "Server is down or misbehaving")
-		return nil, nil
+		logging.Error("Server is down or misbehaving", "user", username, "path", relativePath, "requestid", requestID)
+		return nil, cached, false, fmt.Errorf("the server is down or misbehaving")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, cached, true, nil
 	}
 
 	if res.StatusCode != 200 {
-		log.Output(1, fmt.Sprintf("Server at URL %s rejected user %s's request '%s'. Status code was %s\n", relativePath, username, description, res.Status))
-		userMessage.StatusCode = http.StatusBadRequest
-		userMessage.Message = fmt.Sprintf("Server error %v ", err)
-		return nil, fmt.Errorf("could not access resource %s", description)
+		logging.Warn("Server rejected request", "user", username, "path", relativePath, "description", description, "status", res.Status, "requestid", requestID)
+		return nil, cached, false, fmt.Errorf("could not access resource %s", description)
+	}
+
+	b, _ := io.ReadAll(res.Body)
+	validators = Validators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}
+	return b, validators, false, nil
+}
+
+// Returned by ProtectedFormServerRequest and ProtectedJSONServerRequest when the server
+// answers with a status other than 200 OK
+type RefusalError struct {
+	Status      int    // the HTTP status the server answered with
+	Description string // what we asked it to do
+}
+
+func (e *RefusalError) Error() string {
+	return "the server refused to " + e.Description
+}
+
+// True if err says that the server has no such endpoint, or does not accept the method
+// we used with it: in other words, that the server does not know how to do what we asked.
+func NotSupported(err error) bool {
+	var refusal *RefusalError
+	return errors.As(err, &refusal) && (refusal.Status == http.StatusNotFound || refusal.Status == http.StatusMethodNotAllowed)
+}
+
+// Helper function to send a form to the server, on behalf of a logged-in user.
+// The form is URL-encoded and POSTed to relativePath with the user's token.
+// description is a user-friendly name for the action being requested, which is used to produce error messages.
+// Returns the body of the server's response, or an error if the server could not be reached or rejected the request.
+func ProtectedFormServerRequest(ctx context.Context, username string, description string, relativePath string, form url.Values) ([]byte, error) {
+	user, ok := models.LookupUser(username)
+	if !ok {
+		logging.Warn("Attempt to access the server by non-existent user", "user", username)
+		return nil, fmt.Errorf("user %s tried to access the server, but we don't have any record of that user", username)
 	}
 
+	requestID := logging.RequestIDFrom(ctx)
+	logging.Debug("Server form request", "user", username, "path", relativePath, "description", description, "requestid", requestID)
+	req, err := http.NewRequest(http.MethodPost, APISOURCE+relativePath, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Capitalism reader")
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	if requestID != "" {
+		req.Header.Set(logging.RequestIDHeader, requestID)
+	}
+
+	client := &http.Client{Timeout: time.Second * 2}
+	started := time.Now()
+	res, err := client.Do(req)
+	metrics.ObserveBackend(relativePath, started, err, statusOf(res))
+	if err != nil {
+		logging.Error("Server is down or misbehaving", "user", username, "path", relativePath, "requestid", requestID)
+		return nil, fmt.Errorf("could not reach the server to %s", description)
+	}
 	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
 
+	if res.StatusCode != http.StatusOK {
+		logging.Warn("Server rejected request", "user", username, "path", relativePath, "description", description, "status", res.Status, "requestid", requestID)
+		return b, &RefusalError{Status: res.StatusCode, Description: description}
+	}
+	return b, nil
+}
+
+// Helper function to send JSON to the server, on behalf of a logged-in user.
+// payload is marshalled and POSTed to relativePath with the user's token.
+// description is a user-friendly name for the action being requested, which is used to produce error messages.
+// Returns the body of the server's response, or an error if the server could not be reached or rejected the request.
+func ProtectedJSONServerRequest(ctx context.Context, username string, description string, relativePath string, payload any) ([]byte, error) {
+	user, ok := models.LookupUser(username)
+	if !ok {
+		logging.Warn("Attempt to access the server by non-existent user", "user", username)
+		return nil, fmt.Errorf("user %s tried to access the server, but we don't have any record of that user", username)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	requestID := logging.RequestIDFrom(ctx)
+	logging.Debug("Server JSON request", "user", username, "path", relativePath, "description", description, "requestid", requestID)
+	req, err := http.NewRequest(http.MethodPost, APISOURCE+relativePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Capitalism reader")
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	if requestID != "" {
+		req.Header.Set(logging.RequestIDHeader, requestID)
+	}
+
+	client := &http.Client{Timeout: time.Second * 2}
+	started := time.Now()
+	res, err := client.Do(req)
+	metrics.ObserveBackend(relativePath, started, err, statusOf(res))
+	if err != nil {
+		logging.Error("Server is down or misbehaving", "user", username, "path", relativePath, "requestid", requestID)
+		return nil, fmt.Errorf("could not reach the server to %s", description)
+	}
+	defer res.Body.Close()
 	b, _ := io.ReadAll(res.Body)
 
-	userMessage.StatusCode = http.StatusOK
-	// The content of the user message, if the action succeeds,
-	// should be set by the handler responsible for it.
+	if res.StatusCode != http.StatusOK {
+		logging.Warn("Server rejected request", "user", username, "path", relativePath, "description", description, "status", res.Status, "requestid", requestID)
+		return b, &RefusalError{Status: res.StatusCode, Description: description}
+	}
 	return b, nil
 }
 
+// helper function to obtain the status of a response that may not exist
+func statusOf(res *http.Response) int {
+	if res == nil {
+		return 0
+	}
+	return res.StatusCode
+}
+
 // utility function to diagnose errors in the list of users
 func PrintUsers() {
-	for key, value := range models.Users {
-		fmt.Println("List of users and their contents")
-		fmt.Printf("%s\t:%v\n", key, value)
+	for _, user := range models.AllUsers() {
+		logging.Debug("User record", "user", user.UserName, "contents", fmt.Sprintf("%v", user))
 	}
 }
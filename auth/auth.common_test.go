@@ -0,0 +1,50 @@
+// auth.common_test.go
+// tests that a refusal from the server says why it refused
+
+package auth
+
+import (
+	"capfront/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestNotSupported(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusNotFound, true},
+		{http.StatusMethodNotAllowed, true},
+		{http.StatusInternalServerError, false},
+		{http.StatusUnauthorized, false},
+	}
+	models.AddUser(&models.UserData{UserName: "refused", Token: "token"})
+	oldSource := APISOURCE
+	t.Cleanup(func() { APISOURCE = oldSource })
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		}))
+		APISOURCE = server.URL + "/"
+		_, jsonErr := ProtectedJSONServerRequest(context.Background(), "refused", "clone", "users/clone/1", map[string]int{"a": 1})
+		_, formErr := ProtectedFormServerRequest(context.Background(), "refused", "change password", "auth/password", url.Values{})
+		server.Close()
+		for _, err := range []error{jsonErr, formErr} {
+			var refusal *RefusalError
+			if !errors.As(err, &refusal) || refusal.Status != test.status {
+				t.Errorf("status %d gave error %v", test.status, err)
+			}
+			if NotSupported(err) != test.want {
+				t.Errorf("status %d: NotSupported is %v, want %v", test.status, !test.want, test.want)
+			}
+		}
+	}
+	if NotSupported(errors.New("could not reach the server")) {
+		t.Error("an unreachable server is not a refusal")
+	}
+}
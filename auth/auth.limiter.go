@@ -0,0 +1,128 @@
+// auth.limiter.go
+// throttles repeated failed attempts to log in
+
+package auth
+
+import (
+	"capfront/config"
+	"sync"
+	"time"
+)
+
+// Keeps track of failed login attempts for a number of keys.
+// A key is anything we want to throttle, such as an IP address or a username.
+// After each failure, the key must wait before trying again. The wait doubles
+// with each consecutive failure, up to MaxDelay. After LockoutAfter consecutive
+// failures the key is locked out for LockoutFor.
+// A success clears the record for the key. So does a quiet spell: a key that
+// has not failed for LockoutFor, and is not locked out, is forgotten.
+type Limiter struct {
+	Now          func() time.Time // the clock. Replace it to test the limiter without waiting.
+	BaseDelay    time.Duration    // wait imposed after the first failure
+	MaxDelay     time.Duration    // longest wait imposed before lockout
+	LockoutAfter int              // number of consecutive failures that trigger a lockout
+	LockoutFor   time.Duration    // how long a lockout lasts
+	mu           sync.Mutex
+	failures     map[string]*failureRecord
+	lastPruned   time.Time // when forgotten keys were last removed from failures
+}
+
+// the failure history of one key
+type failureRecord struct {
+	count       int       // consecutive failures so far
+	lastFailure time.Time // when the most recent failure happened
+	retryAfter  time.Time // the key may not try again before this time
+	lockedUntil time.Time // if the key is locked out, when the lockout ends
+}
+
+// Limiter used by the login handler. Its settings come from config.
+var LoginLimiter = NewLimiter()
+
+// Creates a limiter whose settings are taken from config and which uses the real clock.
+func NewLimiter() *Limiter {
+	return &Limiter{
+		Now:          time.Now,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: config.LoginLockoutAfter,
+		LockoutFor:   time.Duration(config.LoginLockoutMinutes) * time.Minute,
+		failures:     make(map[string]*failureRecord),
+	}
+}
+
+// Reports whether key may try to log in now.
+// If not, also returns how long it must wait.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.Now()
+	record, ok := l.failures[key]
+	if !ok || l.forgotten(record, now) {
+		return true, 0
+	}
+	if now.Before(record.lockedUntil) {
+		return false, record.lockedUntil.Sub(now)
+	}
+	if now.Before(record.retryAfter) {
+		return false, record.retryAfter.Sub(now)
+	}
+	return true, 0
+}
+
+// Records a failed attempt by key and works out how long it must now wait.
+func (l *Limiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.Now()
+	l.prune(now)
+	record, ok := l.failures[key]
+	if !ok || l.forgotten(record, now) || (!record.lockedUntil.IsZero() && !now.Before(record.lockedUntil)) {
+		// first failure, or the lockout has expired: start again
+		record = &failureRecord{}
+		l.failures[key] = record
+	}
+	record.count++
+	record.lastFailure = now
+	if record.count >= l.LockoutAfter {
+		record.lockedUntil = now.Add(l.LockoutFor)
+		return
+	}
+	delay := l.BaseDelay << (record.count - 1)
+	if delay > l.MaxDelay || delay <= 0 {
+		delay = l.MaxDelay
+	}
+	record.retryAfter = now.Add(delay)
+}
+
+// Records a successful attempt by key, which clears its history.
+func (l *Limiter) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, key)
+}
+
+// How often Fail looks for keys to forget
+const pruneInterval = time.Minute
+
+// Reports whether record is old enough to be forgotten:
+// any lockout or wait is over and there has been no failure for LockoutFor.
+// The caller must hold l.mu.
+func (l *Limiter) forgotten(record *failureRecord, now time.Time) bool {
+	return !now.Before(record.lockedUntil) && !now.Before(record.retryAfter) &&
+		!now.Before(record.lastFailure.Add(l.LockoutFor))
+}
+
+// Removes the keys that have been forgotten, so that failures does not grow without limit.
+// Does nothing if it was done less than pruneInterval ago.
+// The caller must hold l.mu.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPruned) < pruneInterval {
+		return
+	}
+	l.lastPruned = now
+	for key, record := range l.failures {
+		if l.forgotten(record, now) {
+			delete(l.failures, key)
+		}
+	}
+}
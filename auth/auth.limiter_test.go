@@ -0,0 +1,136 @@
+// auth.limiter_test.go
+// tests of the login limiter, using a clock that the tests move by hand
+
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// a clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time            { return c.now }
+func (c *fakeClock) Advance(d time.Duration)   { c.now = c.now.Add(d) }
+func newFakeClock() *fakeClock                 { return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)} }
+func newTestLimiter(clock *fakeClock) *Limiter { return testLimiter(clock, 3, 15*time.Minute) }
+
+func testLimiter(clock *fakeClock, lockoutAfter int, lockoutFor time.Duration) *Limiter {
+	return &Limiter{
+		Now:          clock.Now,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		LockoutAfter: lockoutAfter,
+		LockoutFor:   lockoutFor,
+		failures:     make(map[string]*failureRecord),
+	}
+}
+
+func TestLimiterDelays(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		wait     time.Duration // time between the last failure and the check
+		allowed  bool
+	}{
+		{"no failures", 0, 0, true},
+		{"one failure, straight away", 1, 0, false},
+		{"one failure, after the delay", 1, time.Second, true},
+		{"two failures, after one delay", 2, time.Second, false},
+		{"two failures, after the doubled delay", 2, 2 * time.Second, true},
+		{"locked out", 3, time.Minute, false},
+		{"lockout over", 3, 15 * time.Minute, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock()
+			limiter := newTestLimiter(clock)
+			for i := 0; i < test.failures; i++ {
+				limiter.Fail("ip:1.2.3.4")
+			}
+			clock.Advance(test.wait)
+			allowed, _ := limiter.Allow("ip:1.2.3.4")
+			if allowed != test.allowed {
+				t.Errorf("Allow = %v, want %v", allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestLimiterReportsWait(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newTestLimiter(clock)
+	limiter.Fail("user:alice")
+	limiter.Fail("user:alice")
+	clock.Advance(500 * time.Millisecond)
+	if _, wait := limiter.Allow("user:alice"); wait != 1500*time.Millisecond {
+		t.Errorf("wait = %v, want 1.5s", wait)
+	}
+}
+
+func TestLimiterKeysAreSeparate(t *testing.T) {
+	limiter := newTestLimiter(newFakeClock())
+	limiter.Fail("user:alice")
+	if allowed, _ := limiter.Allow("user:bob"); !allowed {
+		t.Error("a failure by alice should not hold up bob")
+	}
+}
+
+func TestLimiterSuccessClears(t *testing.T) {
+	limiter := newTestLimiter(newFakeClock())
+	limiter.Fail("user:alice")
+	limiter.Succeed("user:alice")
+	if allowed, _ := limiter.Allow("user:alice"); !allowed {
+		t.Error("a success should clear the failures")
+	}
+}
+
+func TestLimiterDelayIsCapped(t *testing.T) {
+	clock := newFakeClock()
+	limiter := testLimiter(clock, 100, 15*time.Minute)
+	for i := 0; i < 20; i++ {
+		limiter.Fail("user:alice")
+	}
+	if _, wait := limiter.Allow("user:alice"); wait != time.Minute {
+		t.Errorf("wait = %v, want the maximum delay of 1m", wait)
+	}
+}
+
+func TestLimiterFailureAfterLockoutStartsAgain(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newTestLimiter(clock)
+	for i := 0; i < 3; i++ {
+		limiter.Fail("user:alice")
+	}
+	clock.Advance(15 * time.Minute)
+	limiter.Fail("user:alice")
+	clock.Advance(time.Second)
+	if allowed, _ := limiter.Allow("user:alice"); !allowed {
+		t.Error("the first failure after a lockout should impose only the base delay")
+	}
+}
+
+func TestLimiterForgetsQuietKeys(t *testing.T) {
+	clock := newFakeClock()
+	limiter := newTestLimiter(clock)
+	limiter.Fail("user:alice")
+	limiter.Fail("user:bob")
+	clock.Advance(10 * time.Minute)
+	limiter.Fail("user:bob")
+
+	// alice has been quiet for LockoutFor; bob has not
+	clock.Advance(5 * time.Minute)
+	limiter.Fail("user:carol")
+	if _, ok := limiter.failures["user:alice"]; ok {
+		t.Error("alice should have been forgotten")
+	}
+	if record, ok := limiter.failures["user:bob"]; !ok || record.count != 2 {
+		t.Error("bob's failures should have been kept")
+	}
+	if len(limiter.failures) != 2 {
+		t.Errorf("%d keys kept, want 2", len(limiter.failures))
+	}
+}
@@ -0,0 +1,78 @@
+// auth.validate.go
+// rules for usernames and passwords, checked before anything is sent to the server
+
+package auth
+
+import (
+	"fmt"
+	"unicode"
+)
+
+const minUsernameLength = 3
+const minPasswordLength = 8
+
+// Longest username and password that we accept, or pass on to the server
+const MaxUsernameLength = 32
+const MaxPasswordLength = 128
+
+// Checks that username is acceptable.
+// It must be between minUsernameLength and MaxUsernameLength characters long
+// and consist only of letters, digits, '.', '-' and '_'.
+// Returns a message for the user if not, or "" if all is well.
+func ValidateUsername(username string) string {
+	length := len([]rune(username))
+	if length < minUsernameLength || length > MaxUsernameLength {
+		return fmt.Sprintf("The username must be between %d and %d characters long", minUsernameLength, MaxUsernameLength)
+	}
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '-' && r != '_' {
+			return "The username may contain only letters, digits, '.', '-' and '_'"
+		}
+	}
+	return ""
+}
+
+// Checks that password is acceptable.
+// It must be between minPasswordLength and MaxPasswordLength characters long
+// and contain at least one letter and at least one digit.
+// Returns a message for the user if not, or "" if all is well.
+func ValidatePassword(password string) string {
+	length := len([]rune(password))
+	if length < minPasswordLength || length > MaxPasswordLength {
+		return fmt.Sprintf("The password must be between %d and %d characters long", minPasswordLength, MaxPasswordLength)
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		hasLetter = hasLetter || unicode.IsLetter(r)
+		hasDigit = hasDigit || unicode.IsDigit(r)
+	}
+	if !hasLetter || !hasDigit {
+		return "The password must contain at least one letter and at least one digit"
+	}
+	return ""
+}
+
+// Checks a new password and its confirmation.
+// Returns a map from the name of each faulty form field to a message about it.
+// The map is empty if the fields are acceptable.
+func ValidateNewPassword(password string, confirm string) map[string]string {
+	faults := make(map[string]string)
+	if message := ValidatePassword(password); message != "" {
+		faults["password"] = message
+	}
+	if password != confirm {
+		faults["confirm"] = "The two passwords are not the same"
+	}
+	return faults
+}
+
+// Checks the fields of the registration form.
+// Returns a map from the name of each faulty form field to a message about it.
+// The map is empty if the form is acceptable.
+func ValidateRegistration(username string, password string, confirm string) map[string]string {
+	faults := ValidateNewPassword(password, confirm)
+	if message := ValidateUsername(username); message != "" {
+		faults["username"] = message
+	}
+	return faults
+}
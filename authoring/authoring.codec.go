@@ -0,0 +1,80 @@
+// authoring.codec.go
+// reads and writes template definitions as JSON or YAML files
+
+package authoring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The file formats a definition can be written in
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// Interprets a format chosen by the user; anything unrecognised is YAML,
+// which is the easier of the two to edit by hand.
+func ParseFormat(s string) Format {
+	if strings.EqualFold(s, string(JSON)) {
+		return JSON
+	}
+	return YAML
+}
+
+// Guesses the format of an uploaded file from its name
+func FormatOf(filename string) Format {
+	return ParseFormat(strings.TrimPrefix(path.Ext(filename), "."))
+}
+
+// The MIME type to use when sending a file in this format
+func (f Format) ContentType() string {
+	if f == JSON {
+		return "application/json"
+	}
+	return "application/yaml"
+}
+
+// Reads a definition.
+// Fields that a definition does not have are an error rather than being ignored,
+// so that a misspelt name is not silently lost.
+func Decode(data []byte, format Format) (Definition, error) {
+	var def Definition
+	var err error
+	if format == JSON {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&def)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&def)
+	}
+	if err != nil {
+		return def, fmt.Errorf("could not read the template as %s: %w", format, err)
+	}
+	return def, nil
+}
+
+// Writes a definition, indented for people to read
+func Encode(def Definition, format Format) ([]byte, error) {
+	if format == JSON {
+		return json.MarshalIndent(def, "", "  ")
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(def); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+// authoring.definition.go
+// describes a simulation template as the admin writes it, before the server has seen it.
+// Objects refer to each other by name rather than by id, because ids are assigned by the server.
+
+package authoring
+
+import (
+	"capfront/models"
+	"slices"
+)
+
+// A complete template: its parameters and the objects that each simulation cloned from it starts with
+type Definition struct {
+	Name        string                      `json:"name" yaml:"name"`
+	Description string                      `json:"description,omitempty" yaml:"description,omitempty"`
+	Parameters  models.SimulationParameters `json:"parameters" yaml:"parameters"`
+	Commodities []Commodity                 `json:"commodities" yaml:"commodities"`
+	Industries  []Industry                  `json:"industries" yaml:"industries"`
+	Classes     []Class                     `json:"classes" yaml:"classes"`
+}
+
+type Commodity struct {
+	Name          string  `json:"name" yaml:"name"`
+	Origin        string  `json:"origin" yaml:"origin"`
+	Usage         string  `json:"usage" yaml:"usage"`
+	Unit_Value    float32 `json:"unit_value" yaml:"unit_value"`
+	Unit_Price    float32 `json:"unit_price" yaml:"unit_price"`
+	Turnover_Time float32 `json:"turnover_time" yaml:"turnover_time"`
+	Display_Order float32 `json:"display_order" yaml:"display_order"`
+	Image_Name    string  `json:"image_name,omitempty" yaml:"image_name,omitempty"`
+	Tooltip       string  `json:"tooltip,omitempty" yaml:"tooltip,omitempty"`
+}
+
+// Output is the name of the commodity the industry produces
+type Industry struct {
+	Name               string  `json:"name" yaml:"name"`
+	Output             string  `json:"output" yaml:"output"`
+	Output_Scale       float32 `json:"output_scale" yaml:"output_scale"`
+	Output_Growth_Rate float32 `json:"output_growth_rate" yaml:"output_growth_rate"`
+	Stocks             []Stock `json:"stocks" yaml:"stocks"`
+}
+
+type Class struct {
+	Name                string  `json:"name" yaml:"name"`
+	Population          float32 `json:"population" yaml:"population"`
+	Participation_Ratio float32 `json:"participation_ratio" yaml:"participation_ratio"`
+	Consumption_Ratio   float32 `json:"consumption_ratio" yaml:"consumption_ratio"`
+	Stocks              []Stock `json:"stocks" yaml:"stocks"`
+}
+
+// An initial stock, owned by the industry or class under which it appears.
+// Commodity is the name of the commodity it holds.
+// Requirement means something only for the production stocks of industries.
+type Stock struct {
+	Commodity   string  `json:"commodity" yaml:"commodity"`
+	Usage_type  string  `json:"usage_type" yaml:"usage_type"`
+	Size        float32 `json:"size" yaml:"size"`
+	Requirement float32 `json:"requirement,omitempty" yaml:"requirement,omitempty"`
+}
+
+// A small but complete template, as a starting point for the admin
+func Example() Definition {
+	stocks := func(output string, labour float32, means float32) []Stock {
+		return []Stock{
+			{Commodity: "Money", Usage_type: models.MoneyUsage, Size: 1000},
+			{Commodity: output, Usage_type: models.SalesUsage, Size: 0},
+			{Commodity: "Means of Production", Usage_type: models.ProductionUsage, Size: means, Requirement: means},
+			{Commodity: "Labour Power", Usage_type: models.ProductionUsage, Size: labour, Requirement: labour},
+		}
+	}
+	return Definition{
+		Name:        "New template",
+		Description: "Two departments, workers and capitalists",
+		Parameters: models.SimulationParameters{
+			Periods_Per_Year:       1,
+			Population_Growth_Rate: 0,
+			Investment_Ratio:       0,
+			Labour_Supply_Demand:   "FLEXIBLE",
+			Price_Response_Type:    "VALUES",
+			Melt_Response_Type:     "VALUE",
+			Currency_Symbol:        "$",
+			Quantity_Symbol:        "#",
+		},
+		Commodities: []Commodity{
+			{Name: "Money", Origin: models.MoneyOrigin, Usage: models.MoneyCommodity, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 1},
+			{Name: "Means of Production", Origin: models.IndustrialOrigin, Usage: models.ProductiveCommodity, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 2},
+			{Name: "Consumption", Origin: models.IndustrialOrigin, Usage: models.ConsumptionCommodity, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 3},
+			{Name: "Labour Power", Origin: models.SocialOrigin, Usage: models.ProductiveCommodity, Unit_Value: 1, Unit_Price: 1, Turnover_Time: 1, Display_Order: 4},
+		},
+		Industries: []Industry{
+			{Name: "Department I", Output: "Means of Production", Output_Scale: 2000, Stocks: stocks("Means of Production", 400, 1600)},
+			{Name: "Department II", Output: "Consumption", Output_Scale: 1000, Stocks: stocks("Consumption", 200, 800)},
+		},
+		Classes: []Class{
+			{Name: "Workers", Population: 600, Participation_Ratio: 1, Consumption_Ratio: 1, Stocks: []Stock{
+				{Commodity: "Money", Usage_type: models.MoneyUsage, Size: 0},
+				{Commodity: "Labour Power", Usage_type: models.SalesUsage, Size: 600},
+				{Commodity: "Consumption", Usage_type: models.ConsumptionUsage, Size: 0},
+			}},
+			{Name: "Capitalists", Population: 10, Participation_Ratio: 1, Consumption_Ratio: 1, Stocks: []Stock{
+				{Commodity: "Money", Usage_type: models.MoneyUsage, Size: 2000},
+				{Commodity: "Consumption", Usage_type: models.ConsumptionUsage, Size: 0},
+			}},
+		},
+	}
+}
+
+// Builds a definition from a simulation that already exists, so that the admin
+// can use it as the basis for a new template.
+// The lists may contain objects from other simulations; only those belonging to sim are used.
+// Stocks take their sizes from the simulation as it now stands, not as it started.
+func FromSimulation(
+	sim models.Simulation,
+	commodities []models.Commodity,
+	industries []models.Industry,
+	classes []models.Class,
+	industryStocks []models.Industry_Stock,
+	classStocks []models.Class_Stock,
+) Definition {
+	def := Definition{Name: sim.Name, Parameters: sim.Parameters()}
+	commodityNames := make(map[int]string)
+	for _, c := range commodities {
+		if int(c.Simulation_id) != sim.Id {
+			continue
+		}
+		commodityNames[c.Id] = c.Name
+		def.Commodities = append(def.Commodities, Commodity{
+			Name:          c.Name,
+			Origin:        c.Origin,
+			Usage:         c.Usage,
+			Unit_Value:    c.Unit_Value,
+			Unit_Price:    c.Unit_Price,
+			Turnover_Time: c.Turnover_Time,
+			Display_Order: c.Display_Order,
+			Image_Name:    c.Image_Name,
+			Tooltip:       c.Tooltip,
+		})
+	}
+	slices.SortStableFunc(def.Commodities, func(a, b Commodity) int {
+		switch {
+		case a.Display_Order < b.Display_Order:
+			return -1
+		case a.Display_Order > b.Display_Order:
+			return 1
+		}
+		return 0
+	})
+	for _, ind := range industries {
+		if int(ind.Simulation_id) != sim.Id {
+			continue
+		}
+		industry := Industry{Name: ind.Name, Output: ind.Output, Output_Scale: ind.Output_Scale, Output_Growth_Rate: ind.Output_Growth_Rate}
+		for _, s := range industryStocks {
+			if s.Simulation_id == sim.Id && s.Industry_id == ind.Id {
+				industry.Stocks = append(industry.Stocks, Stock{
+					Commodity:   commodityNames[s.Commodity_id],
+					Usage_type:  s.Usage_type,
+					Size:        s.Size,
+					Requirement: s.Requirement,
+				})
+			}
+		}
+		def.Industries = append(def.Industries, industry)
+	}
+	for _, cl := range classes {
+		if int(cl.Simulation_id) != sim.Id {
+			continue
+		}
+		class := Class{Name: cl.Name, Population: cl.Population, Participation_Ratio: cl.Participation_Ratio, Consumption_Ratio: cl.Consumption_Ratio}
+		for _, s := range classStocks {
+			if s.Simulation_id == sim.Id && s.Class_id == cl.Id {
+				class.Stocks = append(class.Stocks, Stock{Commodity: commodityNames[s.Commodity_id], Usage_type: s.Usage_type, Size: s.Size})
+			}
+		}
+		def.Classes = append(def.Classes, class)
+	}
+	return def
+}
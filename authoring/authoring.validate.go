@@ -0,0 +1,132 @@
+// authoring.validate.go
+// checks that a template definition makes sense before it is sent to the server
+
+package authoring
+
+import (
+	"capfront/models"
+	"fmt"
+	"slices"
+)
+
+// The usage types a stock may have, depending on who owns it
+var industryUsages = []string{models.MoneyUsage, models.SalesUsage, models.ProductionUsage}
+var classUsages = []string{models.MoneyUsage, models.SalesUsage, models.ConsumptionUsage}
+
+// Checks the structure of a definition.
+// Every industry must have exactly one money stock, exactly one sales stock holding its output,
+// and at least one production stock. Every class must have exactly one money stock.
+// Every name that refers to a commodity must be the name of a commodity in the definition.
+// Returns a description of each problem found, in the order of the definition; empty if there are none.
+func (d Definition) Validate() []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if d.Name == "" {
+		report("The template has no name")
+	}
+	faults := d.Parameters.Validate()
+	var names []string
+	for name := range faults {
+		names = append(names, name)
+	}
+	slices.Sort(names) // so that problems are always reported in the same order
+	for _, name := range names {
+		report("Parameter %s: %s", name, faults[name])
+	}
+
+	if len(d.Commodities) == 0 {
+		report("There are no commodities")
+	}
+	if len(d.Industries) == 0 {
+		report("There are no industries")
+	}
+	if len(d.Classes) == 0 {
+		report("There are no classes")
+	}
+
+	commodities := make(map[string]bool)
+	for i, c := range d.Commodities {
+		switch {
+		case c.Name == "":
+			report("Commodity %d has no name", i+1)
+		case commodities[c.Name]:
+			report("There is more than one commodity called %q", c.Name)
+		}
+		commodities[c.Name] = true
+		if c.Unit_Value < 0 || c.Unit_Price < 0 {
+			report("Commodity %q has a negative unit value or price", c.Name)
+		}
+		if c.Turnover_Time <= 0 {
+			report("Commodity %q must have a positive turnover time", c.Name)
+		}
+	}
+
+	// Checks the stocks of one owner, and returns them grouped by usage type
+	checkStocks := func(kind string, owner string, stocks []Stock, usages []string) map[string][]Stock {
+		byUsage := make(map[string][]Stock)
+		for _, s := range stocks {
+			if !slices.Contains(usages, s.Usage_type) {
+				report("%s %q has a stock of type %q, which is not one of %v", kind, owner, s.Usage_type, usages)
+			}
+			if !commodities[s.Commodity] {
+				report("%s %q has a %s stock of %q, which is not a commodity", kind, owner, s.Usage_type, s.Commodity)
+			}
+			if s.Size < 0 || s.Requirement < 0 {
+				report("%s %q has a %s stock of %q with a negative size or requirement", kind, owner, s.Usage_type, s.Commodity)
+			}
+			byUsage[s.Usage_type] = append(byUsage[s.Usage_type], s)
+		}
+		if n := len(byUsage[models.MoneyUsage]); n != 1 {
+			report("%s %q must have exactly one money stock, not %d", kind, owner, n)
+		}
+		return byUsage
+	}
+
+	owners := make(map[string]bool)
+	for i, ind := range d.Industries {
+		switch {
+		case ind.Name == "":
+			report("Industry %d has no name", i+1)
+		case owners[ind.Name]:
+			report("There is more than one industry or class called %q", ind.Name)
+		}
+		owners[ind.Name] = true
+		if !commodities[ind.Output] {
+			report("Industry %q produces %q, which is not a commodity", ind.Name, ind.Output)
+		}
+		if ind.Output_Scale < 0 {
+			report("Industry %q has a negative output scale", ind.Name)
+		}
+		stocks := checkStocks("Industry", ind.Name, ind.Stocks, industryUsages)
+		sales := stocks[models.SalesUsage]
+		switch {
+		case len(sales) != 1:
+			report("Industry %q must have exactly one sales stock, not %d", ind.Name, len(sales))
+		case sales[0].Commodity != ind.Output:
+			report("Industry %q sells %q but produces %q", ind.Name, sales[0].Commodity, ind.Output)
+		}
+		if len(stocks[models.ProductionUsage]) == 0 {
+			report("Industry %q has no production stocks", ind.Name)
+		}
+	}
+	for i, cl := range d.Classes {
+		switch {
+		case cl.Name == "":
+			report("Class %d has no name", i+1)
+		case owners[cl.Name]:
+			report("There is more than one industry or class called %q", cl.Name)
+		}
+		owners[cl.Name] = true
+		if cl.Population < 0 {
+			report("Class %q has a negative population", cl.Name)
+		}
+		stocks := checkStocks("Class", cl.Name, cl.Stocks, classUsages)
+		if n := len(stocks[models.SalesUsage]); n > 1 {
+			report("Class %q may have at most one sales stock, not %d", cl.Name, n)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,168 @@
+// cache.go
+// keeps data that is shared between users (for example the list of templates)
+// for a limited time, reloading it from the server when it expires.
+
+package cache
+
+import (
+	"capfront/logging"
+	"context"
+	"sync"
+	"time"
+)
+
+// Something that can be reloaded on demand.
+// Every Cache is one; they are kept in a registry so that the admin can reload them all.
+type Reloader interface {
+	Name() string
+	Reload() error
+	Invalidate()
+}
+
+var registry []Reloader
+var registryLock sync.Mutex
+
+// A value of type T, obtained by calling load, and kept for TTL.
+// If a reload fails, the previous value is kept, so that a temporary
+// failure of the server does not make the data disappear.
+type Cache[T any] struct {
+	name    string
+	TTL     time.Duration
+	Now     func() time.Time // the clock; replace it to control time, for example when testing
+	load    func() (T, error)
+	mu      sync.Mutex
+	value   T
+	fetched time.Time // when value was loaded; zero if it never was, or has been invalidated
+	pending *reload   // the reload in progress, if any
+}
+
+// A reload in progress. done is closed when it finishes, after which err is its outcome.
+type reload struct {
+	done chan struct{}
+	err  error
+}
+
+// Creates a cache and adds it to the registry.
+// Nothing is loaded until the value is first asked for.
+func New[T any](name string, ttl time.Duration, load func() (T, error)) *Cache[T] {
+	c := &Cache[T]{name: name, TTL: ttl, Now: time.Now, load: load}
+	registryLock.Lock()
+	registry = append(registry, c)
+	registryLock.Unlock()
+	return c
+}
+
+func (c *Cache[T]) Name() string {
+	return c.name
+}
+
+// Returns the value, first reloading it if it has expired.
+func (c *Cache[T]) Get() T {
+	c.mu.Lock()
+	stale := c.stale()
+	c.mu.Unlock()
+	if stale {
+		c.Reload() // on failure, the error is logged and we make do with what we have
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// Loads the value afresh, whether or not it has expired.
+// Only one reload runs at a time: if one is already in progress, this waits for it
+// and returns its outcome, so that many requests finding the value stale at once
+// ask the server for it only once.
+func (c *Cache[T]) Reload() error {
+	c.mu.Lock()
+	if r := c.pending; r != nil {
+		c.mu.Unlock()
+		<-r.done
+		return r.err
+	}
+	r := &reload{done: make(chan struct{})}
+	c.pending = r
+	c.mu.Unlock()
+
+	value, err := c.load()
+	c.mu.Lock()
+	if err == nil {
+		c.value = value
+		c.fetched = c.Now()
+	}
+	c.pending = nil
+	r.err = err
+	c.mu.Unlock()
+	close(r.done)
+
+	if err != nil {
+		logging.Warn("Could not reload cached data; keeping what we have", "cache", c.name, "error", err)
+		return err
+	}
+	logging.Debug("Reloaded cached data", "cache", c.name)
+	return nil
+}
+
+// Marks the value as expired, so the next Get reloads it.
+func (c *Cache[T]) Invalidate() {
+	c.mu.Lock()
+	c.fetched = time.Time{}
+	c.mu.Unlock()
+}
+
+// How long ago the value was loaded. Zero if it has not been loaded.
+func (c *Cache[T]) Age() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetched.IsZero() {
+		return 0
+	}
+	return c.Now().Sub(c.fetched)
+}
+
+// helper function: true if the value must be reloaded. Call with c.mu held.
+func (c *Cache[T]) stale() bool {
+	return c.fetched.IsZero() || c.Now().Sub(c.fetched) >= c.TTL
+}
+
+// Reloads every cache in the registry. Returns the names of those that failed.
+func ReloadAll() []string {
+	var failed []string
+	for _, c := range all() {
+		if c.Reload() != nil {
+			failed = append(failed, c.Name())
+		}
+	}
+	return failed
+}
+
+// Marks every cache in the registry as expired.
+func InvalidateAll() {
+	for _, c := range all() {
+		c.Invalidate()
+	}
+}
+
+// Reloads every cache in the background, every interval, until ctx is done.
+// This means users rarely have to wait for a reload.
+func StartRefreshing(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ReloadAll()
+			}
+		}
+	}()
+}
+
+// helper function giving a copy of the registry, so it is not locked while caches reload
+func all() []Reloader {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return append([]Reloader(nil), registry...)
+}
@@ -0,0 +1,127 @@
+// cache_test.go
+// tests of expiry, reloading and invalidation, on a clock the tests control
+
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A loader that counts its calls and returns the next value, or fails if told to
+type countingLoader struct {
+	calls atomic.Int32
+	fail  atomic.Bool
+}
+
+func (l *countingLoader) load() (int, error) {
+	n := int(l.calls.Add(1))
+	if l.fail.Load() {
+		return 0, errors.New("server down")
+	}
+	return n, nil
+}
+
+// helper that makes a cache with the given TTL on a clock that moves only when told to
+func newTestCache(t *testing.T, ttl time.Duration, loader *countingLoader) (*Cache[int], *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New(t.Name(), ttl, loader.load)
+	c.Now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestGetExpires(t *testing.T) {
+	loader := &countingLoader{}
+	c, now := newTestCache(t, time.Minute, loader)
+	tests := []struct {
+		advance time.Duration
+		want    int
+	}{
+		{0, 1},                // loaded when first asked for
+		{30 * time.Second, 1}, // still fresh
+		{29 * time.Second, 1}, // 59s old
+		{time.Second, 2},      // 60s old: expired, so reloaded
+		{59 * time.Second, 2}, // fresh again
+		{10 * time.Minute, 3},
+	}
+	for i, test := range tests {
+		*now = now.Add(test.advance)
+		if got := c.Get(); got != test.want {
+			t.Errorf("step %d: Get() = %d, want %d", i, got, test.want)
+		}
+	}
+	if age := c.Age(); age != 0 {
+		t.Errorf("just after a reload, Age() = %v, want 0", age)
+	}
+}
+
+func TestFailedReloadKeepsValue(t *testing.T) {
+	loader := &countingLoader{}
+	c, now := newTestCache(t, time.Minute, loader)
+	if got := c.Get(); got != 1 {
+		t.Fatalf("Get() = %d, want 1", got)
+	}
+	loader.fail.Store(true)
+	*now = now.Add(2 * time.Minute)
+	if got := c.Get(); got != 1 {
+		t.Errorf("after a failed reload, Get() = %d, want the old value 1", got)
+	}
+	if err := c.Reload(); err == nil {
+		t.Error("Reload reported success although the load failed")
+	}
+	loader.fail.Store(false)
+	if got := c.Get(); got != 4 {
+		t.Errorf("once the server is back, Get() = %d, want 4", got)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	loader := &countingLoader{}
+	c, _ := newTestCache(t, time.Hour, loader)
+	c.Get()
+	c.Invalidate()
+	if age := c.Age(); age != 0 {
+		t.Errorf("after Invalidate, Age() = %v, want 0", age)
+	}
+	if got := c.Get(); got != 2 {
+		t.Errorf("after Invalidate, Get() = %d, want a reloaded 2", got)
+	}
+	if got := c.Get(); got != 2 {
+		t.Errorf("Get() = %d, want 2 with no further reload", got)
+	}
+}
+
+// Many callers finding the value stale at once cause one load, not one each
+func TestConcurrentGetLoadsOnce(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	c := New(t.Name(), time.Minute, func() (int, error) {
+		calls.Add(1)
+		<-release
+		return 7, nil
+	})
+	var wg sync.WaitGroup
+	results := make([]int, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.Get()
+		}(i)
+	}
+	// Give the callers time to pile up behind the first load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d loads, want 1", n)
+	}
+	for i, got := range results {
+		if got != 7 {
+			t.Errorf("caller %d got %d, want 7", i, got)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// config.settings.go
+// settings that control the behaviour of this frontend.
+// Each setting has a default, which can be overridden by an environment variable.
+
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// The number of simulations each user may create.
+// The admin can override this for individual users.
+var SimulationQuota = 5
+
+// The number of consecutive failed logins, from one address or for one username,
+// after which further attempts are locked out.
+var LoginLockoutAfter = 5
+
+// How long (in minutes) a lockout lasts.
+var LoginLockoutMinutes = 15
+
+// Format of the log: "text" or "json"
+var LogFormat = "text"
+
+// Verbosity of the log: "debug", "info", "warn" or "error"
+var LogLevel = "info"
+
+// Directory in which this frontend keeps the files it writes, such as the audit trail.
+// By default it is "capfront" in the user's configuration directory (see os.UserConfigDir)
+// or, if there is none, the directory holding the executable, so that it does not
+// depend on the directory the frontend was started from.
+var StateDir = defaultStateDir()
+
+// File in which the audit trail is kept. If it is not absolute, it is in StateDir.
+var AuditFile = "audit.jsonl"
+
+// Size (in kilobytes) at which the audit trail is rotated
+var AuditMaxKilobytes = 10240
+
+// Number of rotated audit trail files to keep
+var AuditBackups = 5
+
+// Address on which this frontend listens.
+// Heroku and similar hosts supply only the port, in PORT.
+var Address = ":8080"
+
+// Limits (in seconds) on reading a request, writing a response,
+// and keeping an idle connection open
+var ReadTimeoutSeconds = 10
+var WriteTimeoutSeconds = 30
+var IdleTimeoutSeconds = 120
+
+// Longest time (in seconds) to wait, when shutting down, for requests in progress to finish
+var ShutdownTimeoutSeconds = 20
+
+// Certificate and key for serving HTTPS. If either is empty, we serve plain HTTP.
+var TLSCertFile = ""
+var TLSKeyFile = ""
+
+// How numbers are displayed: the number of significant digits shown
+// (though digits before the decimal point are never dropped)
+// and the separator between each group of three digits
+var SignificantDigits = 4
+var ThousandsSeparator = ","
+
+// Directory in which users' sessions and tables are saved, so that they survive a restart.
+// If it is not absolute, it is in StateDir. If empty, sessions are not saved.
+var SessionDir = "sessions"
+
+// Secret from which the key that encrypts the access tokens in saved sessions is made.
+// If empty, tokens are not saved, so everybody must log in again after a restart.
+var SessionKey = ""
+
+// How long (in seconds) data shared between users, such as the list of templates,
+// is kept before it is reloaded from the server
+var SharedCacheSeconds = 300
+
+// Which engine carries out the actions of the circuit: "remote" (the server) or
+// "local" (package engine, on this frontend's own copy of the tables, which the server never sees).
+// What the local engine does is kept only in memory, so it is lost on restart or resync.
+var Engine = "remote"
+
+// Comma-separated addresses or CIDR ranges of the reverse proxies in front of this frontend.
+// Only these are believed when they say, in X-Forwarded-For, where a request came from.
+// If empty, no proxy is believed and the client is the address that connected to us.
+var TrustedProxies = ""
+
+// Path, relative to the server's URL, to which the template editor sends new templates.
+// The editor POSTs the template as JSON, in the form it exports (see authoring.Definition),
+// with the admin's token, and expects 200 OK, after which it reloads the list of templates.
+// The server does not yet provide such an endpoint, so this is empty by default, and the
+// editor then says that it can only check, convert and export templates.
+var TemplateUploadPath = ""
+
+// Secret that scrapers must present, as "Authorization: Bearer <token>", to read /metrics.
+// If empty, /metrics answers only requests made from this machine.
+var MetricsToken = ""
+
+// Reads the settings from environment variables, where these are provided.
+// Settings that are not provided keep their defaults.
+func Load() {
+	SimulationQuota = intFromEnv("CAPFRONT_SIMULATION_QUOTA", SimulationQuota)
+	LoginLockoutAfter = intFromEnv("CAPFRONT_LOGIN_LOCKOUT_AFTER", LoginLockoutAfter)
+	LoginLockoutMinutes = intFromEnv("CAPFRONT_LOGIN_LOCKOUT_MINUTES", LoginLockoutMinutes)
+	LogFormat = stringFromEnv("CAPFRONT_LOG_FORMAT", LogFormat)
+	LogLevel = stringFromEnv("CAPFRONT_LOG_LEVEL", LogLevel)
+	StateDir = stringFromEnv("CAPFRONT_STATE_DIR", StateDir)
+	AuditFile = inStateDir(stringFromEnv("CAPFRONT_AUDIT_FILE", AuditFile))
+	AuditMaxKilobytes = intFromEnv("CAPFRONT_AUDIT_MAX_KB", AuditMaxKilobytes)
+	AuditBackups = intFromEnv("CAPFRONT_AUDIT_BACKUPS", AuditBackups)
+	if port, ok := os.LookupEnv("PORT"); ok {
+		Address = ":" + port
+	}
+	Address = stringFromEnv("CAPFRONT_ADDRESS", Address)
+	ReadTimeoutSeconds = intFromEnv("CAPFRONT_READ_TIMEOUT", ReadTimeoutSeconds)
+	WriteTimeoutSeconds = intFromEnv("CAPFRONT_WRITE_TIMEOUT", WriteTimeoutSeconds)
+	IdleTimeoutSeconds = intFromEnv("CAPFRONT_IDLE_TIMEOUT", IdleTimeoutSeconds)
+	ShutdownTimeoutSeconds = intFromEnv("CAPFRONT_SHUTDOWN_TIMEOUT", ShutdownTimeoutSeconds)
+	TLSCertFile = stringFromEnv("CAPFRONT_TLS_CERT", TLSCertFile)
+	TLSKeyFile = stringFromEnv("CAPFRONT_TLS_KEY", TLSKeyFile)
+	SignificantDigits = intFromEnv("CAPFRONT_SIGNIFICANT_DIGITS", SignificantDigits)
+	ThousandsSeparator = stringFromEnv("CAPFRONT_THOUSANDS_SEPARATOR", ThousandsSeparator)
+	SessionDir = inStateDir(stringFromEnv("CAPFRONT_SESSION_DIR", SessionDir))
+	SessionKey = stringFromEnv("CAPFRONT_SESSION_KEY", SessionKey)
+	SharedCacheSeconds = intFromEnv("CAPFRONT_SHARED_CACHE_TTL", SharedCacheSeconds)
+	Engine = choiceFromEnv("CAPFRONT_ENGINE", Engine, "remote", "local")
+	TrustedProxies = stringFromEnv("CAPFRONT_TRUSTED_PROXIES", TrustedProxies)
+	TemplateUploadPath = stringFromEnv("CAPFRONT_TEMPLATE_UPLOAD_PATH", TemplateUploadPath)
+	MetricsToken = stringFromEnv("CAPFRONT_METRICS_TOKEN", MetricsToken)
+}
+
+// helper function giving the directory that StateDir has if it is not set
+func defaultStateDir() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "capfront")
+	}
+	if executable, err := os.Executable(); err == nil {
+		return filepath.Dir(executable)
+	}
+	return "."
+}
+
+// helper function that puts path in StateDir, unless it is empty or absolute
+func inStateDir(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(StateDir, path)
+}
+
+// helper function to read a string setting from the environment.
+// If the variable is missing, return the default.
+func stringFromEnv(name string, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// helper function to read a setting that must be one of choices.
+// If the variable is missing or is not one of them, return the default.
+func choiceFromEnv(name string, fallback string, choices ...string) string {
+	value := stringFromEnv(name, fallback)
+	if !slices.Contains(choices, value) {
+		slog.Warn("Setting is not one of the permitted values; using the default", "setting", name, "value", value, "permitted", choices, "default", fallback)
+		return fallback
+	}
+	return value
+}
+
+// helper function to read an integer setting from the environment.
+// If the variable is missing or malformed, return the default.
+func intFromEnv(name string, fallback int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Setting is not a whole number; using the default", "setting", name, "value", value, "default", fallback)
+		return fallback
+	}
+	return result
+}
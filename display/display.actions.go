@@ -4,14 +4,20 @@
 package display
 
 import (
+	"capfront/analysis"
 	"capfront/api"
+	"capfront/audit"
 	"capfront/auth"
+	"capfront/config"
+	"capfront/engine"
+	"capfront/logging"
+	"capfront/metrics"
 	"capfront/models"
+	"capfront/persist"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,35 +30,55 @@ type Action struct {
 // Handles requests for the server to take an action comprising a stage
 // of the circuit (demand,supply, trade, produce, invest), corresponding
 // to a button press. This is specified by the URL parameter 'act'
+// If config.Engine is "local", the action is taken by package engine instead of the server
 // Having requested the action from ths server, sets 'state' to the next
 // stage of the circuit and redisplays whatever the user was looking at
 func ActionHandler(ctx *gin.Context) {
-	log.Output(1, "Entered actionHandler")
 	var param Action
 	err := ctx.ShouldBindUri(&param)
 	if err != nil {
-		fmt.Println("Something went wrong", err)
+		logging.Warn("Malformed action URL", "path", ctx.Request.URL.Path, "error", err)
 		ctx.String(http.StatusBadRequest, "Malformed URL")
 		return
 	}
 	act := ctx.Param("action")
 	username, _ := auth.Get_current_user(ctx)
-	lastVisitedPage := models.Users[username].LastVisitedPage
-	log.Output(1, fmt.Sprintf("User %s wants the server to do %s\n", username, act))
-	log.Output(1, fmt.Sprintf("Last visited page %s", lastVisitedPage))
-	auth.ProtectedResourceServerRequest(username, act, `action/`+act)
+	lastVisitedPage := models.User(username).LastVisitedPage
+	logging.Info("User requested an action", "user", username, "action", act, "lastpage", lastVisitedPage)
+	if config.Engine != "local" && refuseOtherSimulation(ctx, username, act) {
+		return
+	}
+	before := models.User(username).TakeSnapshot()
+	started := time.Now()
+	var actionErr error
+	if config.Engine == "local" {
+		actionErr = engine.Run(models.User(username), act)
+	} else {
+		_, actionErr = auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, act, `action/`+act)
+	}
+	audit.Record(username, models.User(username).CurrentSimulation, act, actionErr, time.Since(started), "")
+	metrics.ObserveAction(act, actionErr)
 
-	// The action was taken. Now refresh from the server
+	// The action was taken. Now refresh from the server, unless it was taken here
 
-	if !api.Refresh(ctx, username) {
-		log.Output(1, "Warning: refresh was incomplete")
+	refreshed := true
+	if config.Engine != "local" && !api.Refresh(ctx.Request.Context(), username) {
+		refreshed = false
+		logging.Warn("Refresh after action was incomplete", "user", username, "action", act)
 		ctx.HTML(http.StatusOK, "errors.html", gin.H{
 			"message": "The action was done but we failed to retrieve all the data from the server",
 		})
 	}
 
 	// TODO use the state information supplied by the server - this code duplicates the server's prerogative
-	user := models.Users[username]
+	user := models.User(username)
+	user.UserMessage = &models.UserMessage{StatusCode: http.StatusOK}
+	// Only a complete refresh tells us what the action did: a partial one would
+	// show tables that did not change as if the action had left them alone
+	if actionErr == nil && refreshed {
+		user.RecordTransition(act, before)
+		user.UnseenAction = true
+	}
 	switch act {
 	case "demand":
 		set_current_state(username, "SUPPLY")
@@ -76,41 +102,168 @@ func ActionHandler(ctx *gin.Context) {
 		set_current_state(username, "UNKNOWN")
 		user.UserMessage.Message = "There has been a programme error of some kind"
 	}
+	if config.Engine == "local" && actionErr == nil {
+		// Only we know what the action did, so keep it safe from the next refresh
+		user.KeepLocalRun(user.CurrentSimulation)
+	}
+	persist.SaveUser(user)
 	// If the user has just visited a page that displays (but does not act!!!!), redirect to it.
 	// If not, redirect to the Index page
 	// This is a very crude mechanism
-	visitedPageURL := strings.Split(lastVisitedPage, "/")
-	log.Output(1, fmt.Sprintf("The last page this user visited was %v and this was split into%v", lastVisitedPage, visitedPageURL))
-	// v := visitedPageURL[0]
-	if lastVisitedPage == `/commodities` || lastVisitedPage == `/industries` || lastVisitedPage == `/classes` || lastVisitedPage == `/stocks` {
-		fmt.Print("redirection")
+	if lastVisitedPage == `/commodities` || lastVisitedPage == `/industries` || lastVisitedPage == `/classes` ||
+		lastVisitedPage == `/industry_stocks` || lastVisitedPage == `/class_stocks` {
+		logging.Debug("Redirecting to last visited page", "user", username, "page", lastVisitedPage)
 		ctx.Redirect(http.StatusMovedPermanently, lastVisitedPage)
 	} else {
-		fmt.Print("not redirecting")
+		logging.Debug("Redirecting to index page", "user", username, "lastpage", lastVisitedPage)
 		ctx.Redirect(http.StatusMovedPermanently, "/index")
 	}
 	// //TODO set time stamp
-	// TODO why don't the log statements (or their println equivalents) produce any output?
+}
+
+// helper function for the pages that ActionHandler redirects to.
+// If the user has not yet seen what their last action did, returns the changes it made
+// (once only: the next page will not show them again). Otherwise returns nil.
+// The admin, viewing someone else's simulation, is not shown their actions.
+func whatHappened(ctx *gin.Context, username string) *analysis.ActionDiff {
+	user := models.User(username)
+	if user == nil || !user.UnseenAction || len(user.History) == 0 || ctx.GetString("viewas") != "" {
+		return nil
+	}
+	user.UnseenAction = false
+	diff := analysis.Diff(user.History[len(user.History)-1])
+	return &diff
+}
+
+// Sends what the user's last action changed, as JSON, for use by other tools
+func WhatHappenedJSON(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "not logged in"})
+		return
+	}
+	history := models.User(username).History
+	if len(history) == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no action has been taken since you logged in"})
+		return
+	}
+	ctx.JSON(http.StatusOK, analysis.Diff(history[len(history)-1]))
 }
 
 // Creates a new simulation for the logged-in user, from the template specified by the 'id' parameter
+// Refuses if the user has already used up their quota of simulations.
 func CreateSimulation(ctx *gin.Context) {
 	username, _ := auth.Get_current_user(ctx)
 	template_id := ctx.Param("id")
-	body, _ := auth.ProtectedResourceServerRequest(username, " get user details ", `users/`+username)
-	jsonErr := json.Unmarshal(body, &models.UserServerItem)
-	auth.ProtectedResourceServerRequest(username, " create simulation ", `users/clone/`+template_id)
+	user, ok := models.LookupUser(username)
+	if !ok {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	if refuseOverQuota(ctx, user) {
+		return
+	}
+	cloneTemplate(ctx, username, template_id, nil)
+}
+
+// Returned when the server would carry out an action on a simulation other than the user's
+var errOtherSimulation = errors.New("the server's current simulation is not the user's")
+
+// The server carries out actions on whichever simulation it takes to be the user's current one,
+// and it cannot be told which that is. It is usually the one the user is looking at, but not
+// after a sweep, which leaves the server on the last simulation it created (and deleted).
+// If the two differ, or the server cannot tell us, displays an error page and returns true,
+// so that an action never changes a simulation the user is not looking at.
+func refuseOtherSimulation(ctx *gin.Context, username string, act string) bool {
+	current := models.User(username).CurrentSimulation
+	serverSimulation, err := serverCurrentSimulation(ctx, username)
+	if err == nil && serverSimulation == current {
+		return false
+	}
+	message := "Sorry, we could not check with the server which simulation it is working on. Please try again later."
+	if err == nil {
+		err = errOtherSimulation
+		message = "The server is working on a different simulation from the one you are looking at, so this action would change the wrong one. " +
+			"This happens after a parameter sweep. The server cannot yet be told to go back, so please create a new simulation to go on."
+	}
+	logging.Warn("Refused action because the server would act on another simulation", "user", username, "action", act,
+		"simulation", current, "serversimulation", serverSimulation, "error", err)
+	audit.Record(username, current, act, err, 0, "refused: the server's current simulation is not the user's")
+	metrics.ObserveAction(act, err)
+	ctx.HTML(http.StatusConflict, "errors.html", gin.H{"message": message})
+	return true
+}
+
+// Displays the quota page and returns true if the user may not create another simulation.
+func refuseOverQuota(ctx *gin.Context, user *models.UserData) bool {
+	if !user.QuotaExhausted() {
+		return false
+	}
+	logging.Info("User has used up their quota of simulations", "user", user.UserName, "quota", user.Quota())
+	ctx.HTML(http.StatusForbidden, "quota.html", gin.H{
+		"Title":          "Too many simulations",
+		"simulations":    user.SimulationList,
+		"quota":          user.Quota(),
+		"username":       user.UserName,
+		"loggedinstatus": user.LoggedIn,
+		"state":          get_current_state(user.UserName),
+	})
+	return true
+}
+
+// Asks the server to clone a template for the user, makes the clone the user's
+// current simulation and displays it.
+// If overrides is not nil, it is POSTed as JSON to the clone endpoint, asking the server to use
+// its parameters instead of those of the template. The server documents only the GET form of
+// this endpoint, so this is our proposal rather than its API: if the server answers that it
+// has no such endpoint or method (404 or 405), we make an ordinary clone and tell the user
+// that their parameters were not used.
+func cloneTemplate(ctx *gin.Context, username string, template_id string, overrides *models.SimulationParameters) {
+	body, _ := auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, " get user details ", `users/`+username)
+	var serverItem models.UserServerData
+	jsonErr := json.Unmarshal(body, &serverItem)
+	started := time.Now()
+	var cloneErr error
+	detail := "template " + template_id
+	parametersIgnored := false
+	if overrides != nil {
+		_, cloneErr = auth.ProtectedJSONServerRequest(ctx.Request.Context(), username, " create simulation ", `users/clone/`+template_id, overrides)
+		detail += " with changed parameters"
+		if auth.NotSupported(cloneErr) {
+			logging.Warn("Server does not accept changed parameters; cloning the template as it is", "user", username, "template", template_id, "error", cloneErr)
+			parametersIgnored = true
+			detail = "template " + template_id + " without the changed parameters, which the server does not accept"
+		}
+	}
+	if overrides == nil || parametersIgnored {
+		_, cloneErr = auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, " create simulation ", `users/clone/`+template_id)
+	}
+	audit.Record(username, 0, "create", cloneErr, time.Since(started), detail)
+	if cloneErr != nil {
+		logging.Warn("Server would not create simulation", "user", username, "template", template_id, "error", cloneErr)
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": "Sorry, the server would not create this simulation. Please try again later.",
+		})
+		return
+	}
 	if jsonErr != nil {
-		log.Output(1, "Failed to obtain user details while creating a new simulation - cannot set current simulation right now")
+		logging.Warn("Failed to obtain user details while creating a new simulation - cannot set current simulation right now", "user", username)
 	} else {
-		log.Output(1, fmt.Sprintf("Setting current simulation to be %d", models.UserServerItem.CurrentSimulation))
-		models.Users[username].CurrentSimulation = models.UserServerItem.CurrentSimulation
+		logging.Debug("Setting current simulation", "user", username, "simulation", serverItem.CurrentSimulation)
+		models.User(username).CurrentSimulation = serverItem.CurrentSimulation
 	}
-	if !api.Refresh(ctx, username) {
-		log.Output(1, "Warning: refresh was incomplete")
+	if !api.Refresh(ctx.Request.Context(), username) {
+		logging.Warn("Refresh after creating simulation was incomplete", "user", username)
 		ctx.HTML(http.StatusOK, "errors.html", gin.H{
 			"message": "Warning: we created this simulation but failed to retrieve all the data from the server",
 		})
+		return
+	}
+	if parametersIgnored {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": "We created this simulation, but with the template's own parameters: the server does not yet accept changed parameters",
+		})
+		return
 	}
 
 	ShowIndexPage(ctx)
@@ -0,0 +1,100 @@
+// display.actions_test.go
+// tests that an action is sent to the server only when the server is working on the user's simulation
+
+package display
+
+import (
+	"capfront/auth"
+	"capfront/config"
+	"capfront/models"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A fake server that says which simulation is the user's current one, counts the actions it is
+// asked for, and sends a table of one row for simulation 3 whatever table is asked for
+type fakeActionServer struct {
+	mu                sync.Mutex
+	currentSimulation int
+	actions           int
+	requests          []string
+}
+
+func (f *fakeActionServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.URL.Path)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/users/"):
+		fmt.Fprintf(w, `{"current_simulation": %d, "is_logged_in": true}`, f.currentSimulation)
+	case strings.HasPrefix(r.URL.Path, "/action/"):
+		f.actions++
+	default: // an empty table counts as a failed refresh, so send one row
+		w.Write([]byte(`[{"id": 3, "simulation_id": 3}]`))
+	}
+}
+
+// Points the auth helpers at a fake server, and makes a user whose current simulation is 3.
+// Returns the server, and a router that serves the given handler with a stand-in for errors.html.
+func startFakeActionServer(t *testing.T, username string, route string, handler gin.HandlerFunc) (*fakeActionServer, *gin.Engine) {
+	fake := &fakeActionServer{}
+	server := httptest.NewServer(fake)
+	oldSource, oldEngine := auth.APISOURCE, config.Engine
+	auth.APISOURCE, config.Engine = server.URL+"/", "remote"
+	t.Cleanup(func() {
+		server.Close()
+		auth.APISOURCE, config.Engine = oldSource, oldEngine
+	})
+	models.AddUser(&models.UserData{UserName: username, Token: "token", LoggedIn: true, CurrentSimulation: 3, UserMessage: &models.UserMessage{}})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.SetHTMLTemplate(template.Must(template.New("errors.html").Parse(`{{ .message }}`)))
+	router.GET(route, handler)
+	return fake, router
+}
+
+// helper that asks router for path, with the cookie of the user called username
+func requestAs(router *gin.Engine, username string, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", path, nil)
+	request.AddCookie(&http.Cookie{Name: "User", Value: username})
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestActionOnlyOnTheUsersSimulation(t *testing.T) {
+	tests := []struct {
+		name             string
+		serverSimulation int
+		wantActions      int
+		wantStatus       int
+	}{
+		{"server agrees", 3, 1, http.StatusMovedPermanently},
+		{"server left on a sweep's simulation", 99, 0, http.StatusConflict},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			username := fmt.Sprintf("actionguard%d", i)
+			fake, router := startFakeActionServer(t, username, "/action/:action", ActionHandler)
+			fake.currentSimulation = test.serverSimulation
+			recorder := requestAs(router, username, "/action/demand")
+			if fake.actions != test.wantActions {
+				t.Errorf("the server was asked for %d actions, want %d", fake.actions, test.wantActions)
+			}
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if got := models.User(username).CurrentSimulation; got != 3 {
+				t.Errorf("the user's current simulation became %d", got)
+			}
+		})
+	}
+}
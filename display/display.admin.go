@@ -4,18 +4,27 @@
 package display
 
 import (
+	"capfront/api"
+	"capfront/audit"
 	"capfront/auth"
+	"capfront/cache"
+	"capfront/logging"
 	"capfront/models"
+	"capfront/persist"
+	"capfront/sweep"
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Display the admin dashboard
 func AdminDashboard(ctx *gin.Context) {
-	username, loginStatus, _ := userStatus(ctx)
+	username, loginStatus, _ := adminStatus(ctx)
 	if !loginStatus {
 		ctx.Redirect(http.StatusMovedPermanently, "/login")
 		return
@@ -27,30 +36,290 @@ func AdminDashboard(ctx *gin.Context) {
 		})
 		return
 	}
+	api.AdminUsers.Get() // so that users who have registered since the last reload appear
+
+	// Copy each user while holding their lock, because their own requests may be changing them
+	var users []models.UserData
+	for _, user := range models.AllUsers() {
+		unlock := lockOther(ctx, user.UserName)
+		users = append(users, *user)
+		unlock()
+	}
 	ctx.HTML(http.StatusOK, "admin-dashboard.html", gin.H{
 		"Title":          "Admin Dashboard",
-		"users":          models.Users,
+		"users":          users,
 		"username":       username,
 		"loggedinstatus": loginStatus,
+		"viewas":         models.User(username).ViewAs,
+		"message":        ctx.GetString("adminmessage"),
+		"offerresync":    ctx.GetBool("offerresync"),
 	})
 }
 
+// helper function for admin handlers.
+// Works like userStatus, but always returns the name of the user who is actually
+// logged in, even if the admin is viewing another user's simulation.
+func adminStatus(ctx *gin.Context) (string, bool, error) {
+	username, loginStatus, err := userStatus(ctx)
+	if ctx.GetString("viewas") != "" {
+		username, _ = auth.Get_current_user(ctx)
+	}
+	return username, loginStatus, err
+}
+
+// Lets the admin see what another user sees.
+// Downloads that user's tables, using the admin's credentials, into a copy kept
+// under models.ViewKey, and then displays them on the normal pages, with a banner,
+// until the admin stops viewing. The other user's own record is not touched.
+// While this is going on, all actions are blocked by ReadOnlyGuard.
+func AdminViewUser(ctx *gin.Context) {
+	username, loginStatus, _ := adminStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	if username != "admin" {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Errorf("only administrator can view other users' simulations"),
+		})
+		return
+	}
+
+	subject := ctx.Param("username")
+	if _, ok := models.LookupUser(subject); !ok || subject == "admin" {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Sprintf("There is no user called %s whose simulation you can view", subject),
+		})
+		return
+	}
+
+	logging.Info("Admin wants to view the simulation of another user", "subject", subject)
+
+	// Find out which simulation the subject is using
+	view := &models.UserData{LoggedIn: true}
+	var subjectServerData models.UserServerData
+	body, err := auth.ProtectedResourceServerRequestAs(ctx.Request.Context(), username, subject, " get user details ", `users/`+subject)
+	if err == nil && json.Unmarshal(body, &subjectServerData) == nil {
+		view.CurrentSimulation = subjectServerData.CurrentSimulation
+	}
+
+	if !api.RefreshAs(ctx.Request.Context(), username, subject, view) {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Sprintf("Sorry, we could not retrieve the simulation of user %s from the server", subject),
+		})
+		return
+	}
+
+	key := models.ViewKey(username, subject)
+	view.SetOwner(key)
+	models.SetView(key, view)
+	models.User(username).ViewAs = subject
+	audit.Record(username, view.CurrentSimulation, "view", nil, 0, "viewing the simulation of "+subject)
+	ctx.Redirect(http.StatusMovedPermanently, "/index")
+}
+
+// Ends the admin's read-only view of another user's simulation
+func AdminStopViewing(ctx *gin.Context) {
+	username, loginStatus, _ := adminStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	if username == "admin" {
+		stopViewing(username)
+	}
+	ctx.Redirect(http.StatusMovedPermanently, "/admin/dashboard")
+}
+
+// helper function that discards the copy of another user's tables that the admin was looking at, if any
+func stopViewing(username string) {
+	user := models.User(username)
+	if user.ViewAs == "" {
+		return
+	}
+	logging.Info("Admin stopped viewing the simulation of another user", "subject", user.ViewAs)
+	models.SetView(models.ViewKey(username, user.ViewAs), nil)
+	user.ViewAs = ""
+}
+
+// Middleware for every endpoint that changes anything: a simulation, a sweep, the database, or a user's settings.
+// If the admin is viewing another user's simulation, refuses the request.
+func ReadOnlyGuard(ctx *gin.Context) {
+	username, err := auth.Get_current_user(ctx)
+	if err == nil {
+		if user, ok := models.LookupUser(username); ok && user.ViewAs != "" {
+			logging.Info("Blocked action because admin is viewing another user's simulation", "path", ctx.Request.URL.Path, "subject", user.ViewAs)
+			ctx.HTML(http.StatusForbidden, "errors.html", gin.H{
+				"message": fmt.Sprintf("You are viewing the simulation of %s, which is read-only. Stop viewing to do this.", user.ViewAs),
+			})
+			ctx.Abort()
+			return
+		}
+	}
+	ctx.Next()
+}
+
+// Middleware for every endpoint that changes the user's simulations.
+// If the user has a sweep running, refuses the request, since the sweep is using their current simulation.
+func SweepGuard(ctx *gin.Context) {
+	username, err := auth.Get_current_user(ctx)
+	if err == nil && sweep.IsRunning(username) {
+		logging.Info("Blocked action because a sweep is running", "path", ctx.Request.URL.Path, "user", username)
+		ctx.HTML(http.StatusForbidden, "errors.html", gin.H{
+			"message": "Your parameter sweep is still running. Wait for it to finish, or cancel it, to do this.",
+		})
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
 // Resets the main database
 // Only available to admin
 func AdminReset(ctx *gin.Context) {
 	username, _ := auth.Get_current_user(ctx)
 
 	if username != "admin" {
-		log.Output(1, fmt.Sprintf("User %s tried to reset the database", username))
+		logging.Warn("Non-admin user tried to reset the database", "user", username)
+		audit.RecordEntry(audit.Entry{Time: time.Now().UTC(), User: username, Action: "reset", Outcome: "refused"})
 		ShowIndexPage(ctx)
+		return
 	}
 
-	_, jsonErr := auth.ProtectedResourceServerRequest(username, "reset the database", `action/reset`)
+	started := time.Now()
+	_, jsonErr := auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, "reset the database", `action/reset`)
+	audit.Record(username, 0, "reset", jsonErr, time.Since(started), "")
 	if jsonErr != nil {
-		log.Output(1, "Reset failed")
+		logging.Error("Reset failed", "error", jsonErr)
 	} else {
-		log.Output(1, "COMPLETE RESET by admin")
+		logging.Info("COMPLETE RESET by admin")
+		ctx.Set("adminmessage", "The database has been reset. Everybody's tables are now out of date: resync all users.")
+		ctx.Set("offerresync", true)
+	}
+
+	AdminDashboard(ctx)
+}
+
+// Reloads the data shared between users, such as the templates, without waiting for it to expire.
+// Only available to admin.
+func AdminReloadShared(ctx *gin.Context) {
+	username, loginStatus, _ := adminStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	if username != "admin" {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Errorf("only administrator can reload shared data"),
+		})
+		return
 	}
 
+	started := time.Now()
+	failed := cache.ReloadAll()
+	if len(failed) > 0 {
+		audit.Record(username, 0, "reload", fmt.Errorf("failed: %s", strings.Join(failed, ", ")), time.Since(started), "")
+		ctx.Set("adminmessage", fmt.Sprintf("Could not reload %s from the server. The previous data is still in use.", strings.Join(failed, ", ")))
+	} else {
+		audit.Record(username, 0, "reload", nil, time.Since(started), "")
+		ctx.Set("adminmessage", "Templates and users reloaded from the server.")
+	}
 	AdminDashboard(ctx)
 }
+
+// Sets the number of simulations that one user may create, overriding the default.
+// The quota is supplied by a form on the admin dashboard. Zero means the user may create none;
+// an empty quota restores the default.
+func AdminSetQuota(ctx *gin.Context) {
+	username, loginStatus, _ := adminStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	if username != "admin" {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Errorf("only administrator can change quotas"),
+		})
+		return
+	}
+
+	subject, ok := models.LookupUser(ctx.Param("username"))
+	if !ok {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Sprintf("There is no user called %s", ctx.Param("username")),
+		})
+		return
+	}
+
+	var quota *int
+	detail := "default"
+	if text := strings.TrimSpace(ctx.PostForm("quota")); text != "" {
+		value, err := strconv.Atoi(text)
+		if err != nil || value < 0 {
+			ctx.HTML(http.StatusBadRequest, "errors.html", gin.H{
+				"message": fmt.Sprintf("The quota must be a whole number, zero or more, or empty for the default, not '%s'", text),
+			})
+			return
+		}
+		quota = &value
+		detail = strconv.Itoa(value)
+	}
+
+	logging.Info("Admin set the simulation quota of a user", "subject", subject.UserName, "quota", detail)
+	unlock := lockOther(ctx, subject.UserName)
+	subject.SimulationQuota = quota
+	persist.SaveUser(subject)
+	unlock()
+	audit.Record(username, 0, "quota", nil, 0, fmt.Sprintf("set the quota of %s to %s", subject.UserName, detail))
+	ctx.Redirect(http.StatusMovedPermanently, "/admin/dashboard")
+}
+
+// Displays the audit trail, most recent first.
+// The query parameters user, action, outcome and q (free text) filter it.
+func AdminAudit(ctx *gin.Context) {
+	username, loginStatus, _ := adminStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	if username != "admin" {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Errorf("only administrator can see the audit trail"),
+		})
+		return
+	}
+
+	if audit.Log == nil {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": "The audit trail is not available. Check the log to see why.",
+		})
+		return
+	}
+
+	filter := audit.Filter{
+		User:    ctx.Query("user"),
+		Action:  ctx.Query("action"),
+		Outcome: ctx.Query("outcome"),
+		Text:    ctx.Query("q"),
+	}
+	entries, err := audit.Log.Search(filter, 500)
+	if err != nil {
+		logging.Error("Could not read audit trail", "error", err)
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Sprintf("Could not read the audit trail: %v", err),
+		})
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "audit.html", gin.H{
+		"Title":          "Audit Trail",
+		"entries":        entries,
+		"filter":         filter,
+		"username":       username,
+		"loggedinstatus": loginStatus,
+	})
+}
@@ -0,0 +1,239 @@
+// display.analysis.go
+// handlers for pages that analyse the current simulation, rather than simply listing its objects
+
+package display
+
+import (
+	"capfront/analysis"
+	"capfront/config"
+	"capfront/engine"
+	"capfront/models"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// helper function to build the input-output table of the user's current simulation,
+// measured as the query parameter 'mode' says (quantities, values or prices)
+func currentIOTable(ctx *gin.Context, username string) (analysis.IOTable, error) {
+	mode, err := analysis.ParseMode(ctx.Query("mode"))
+	if err != nil {
+		return analysis.IOTable{}, err
+	}
+	user := models.User(username)
+	return analysis.BuildIOTable(mode, user.CurrentSimulation, user.CommodityList, user.IndustryList, user.IndustryStockList), nil
+}
+
+// Displays the input-output table of the current simulation, with its technical coefficients
+func ShowIOTable(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	table, err := currentIOTable(ctx, username)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "errors.html", gin.H{"message": err.Error()})
+		return
+	}
+
+	ctx.HTML(http.StatusOK, "iotable.html", gin.H{
+		"Title":          "Input-Output Table",
+		"table":          table,
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+	})
+}
+
+// Sends the input-output table of the current simulation as a CSV file.
+// The query parameter 'show=coefficients' sends the technical coefficients instead of the flows.
+func IOTableCSV(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	table, err := currentIOTable(ctx, username)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	coefficients := ctx.Query("show") == "coefficients"
+	name := "flows"
+	if coefficients {
+		name = "coefficients"
+	}
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="iotable-%d-%s-%s.csv"`, models.User(username).CurrentSimulation, table.Mode, name))
+	ctx.Status(http.StatusOK)
+	table.WriteCSV(ctx.Writer, coefficients)
+}
+
+// Displays the current simulation as a reproduction schema, divided into
+// Department I and Department II, and says whether it can reproduce itself
+func ShowSchema(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	user := models.User(username)
+	ctx.HTML(http.StatusOK, "schema.html", gin.H{
+		"Title":          "Reproduction Schema",
+		"schema":         analysis.BuildSchema(user.CurrentSimulation, user.CommodityList, user.IndustryList, user.IndustryStockList),
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+	})
+}
+
+// The money flows of one action, ready for moneyflow.html
+type moneyCircuit struct {
+	Action string
+	Flows  []analysis.MoneyFlow
+	Sankey analysis.Sankey
+}
+
+// Displays a Sankey diagram of the money that changed hands in each of the user's recent actions,
+// most recent first
+func ShowMoneyFlows(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	history := models.User(username).History
+	var circuits []moneyCircuit
+	for i := len(history) - 1; i >= 0; i-- {
+		flows := analysis.MoneyFlows(history[i].Before, history[i].After)
+		circuits = append(circuits, moneyCircuit{
+			Action: history[i].Action,
+			Flows:  flows,
+			Sankey: analysis.LayoutSankey(flows, 800, 300),
+		})
+	}
+
+	ctx.HTML(http.StatusOK, "moneyflow.html", gin.H{
+		"Title":          "Money Circuit",
+		"circuits":       circuits,
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+	})
+}
+
+// helper function to compare the two of the user's simulations named by the query parameters a and b.
+// Returns an error if either is not one of the user's simulations.
+func currentComparison(ctx *gin.Context, username string) (analysis.Comparison, error) {
+	user := models.User(username)
+	find := func(param string) (models.Simulation, error) {
+		id, err := strconv.Atoi(ctx.Query(param))
+		if err != nil {
+			return models.Simulation{}, fmt.Errorf("choose two simulations to compare")
+		}
+		for _, s := range user.SimulationList {
+			if s.Id == id {
+				return s, nil
+			}
+		}
+		return models.Simulation{}, fmt.Errorf("you have no simulation with id %d", id)
+	}
+	a, err := find("a")
+	if err != nil {
+		return analysis.Comparison{}, err
+	}
+	b, err := find("b")
+	if err != nil {
+		return analysis.Comparison{}, err
+	}
+	return analysis.Compare(a, b, user.CommodityList, user.IndustryList, user.ClassList), nil
+}
+
+// Compares two of the user's simulations side by side.
+// Until two have been chosen, displays only the form for choosing them.
+func ShowComparison(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	page := gin.H{
+		"Title":          "Compare Simulations",
+		"simulations":    models.User(username).SimulationList,
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+	}
+	if ctx.Query("a") != "" || ctx.Query("b") != "" {
+		comparison, err := currentComparison(ctx, username)
+		if err != nil {
+			page["message"] = err.Error()
+		} else {
+			page["comparison"] = comparison
+		}
+	}
+	ctx.HTML(http.StatusOK, "compare.html", page)
+}
+
+// Sends the comparison of two of the user's simulations as a CSV file
+func ComparisonCSV(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	comparison, err := currentComparison(ctx, username)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="compare-%d-%d.csv"`, comparison.A.Id, comparison.B.Id))
+	ctx.Status(http.StatusOK)
+	comparison.WriteCSV(ctx.Writer)
+}
+
+// Compares what the local engine would have done with what the server did,
+// for each of the user's recent actions in their current simulation.
+// When the local engine is in use there is nothing to compare, since the server did nothing.
+func ShowCrossCheck(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	page := gin.H{
+		"Title":          "Cross-check the Local Engine",
+		"tolerance":      engine.CrossCheckTolerance,
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+	}
+	if config.Engine == "local" {
+		page["message"] = "Actions are being carried out by the local engine, so there is nothing from the server to compare them with."
+	} else {
+		page["checks"] = engine.CheckHistory(models.User(username))
+	}
+	ctx.HTML(http.StatusOK, "crosscheck.html", page)
+}
@@ -0,0 +1,186 @@
+// display.authoring.go
+// handlers for the admin's template editor, which writes new templates and sends them to the server
+
+package display
+
+import (
+	"capfront/api"
+	"capfront/audit"
+	"capfront/authoring"
+	"capfront/logging"
+	"capfront/models"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The largest template file the admin may import
+const maxTemplateFileSize = 1 << 20
+
+// helper function for the template editor handlers.
+// Returns false, having displayed something else, unless the admin is logged in.
+func templateEditorAllowed(ctx *gin.Context) bool {
+	username, loginStatus, _ := adminStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return false
+	}
+	if username != "admin" {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Errorf("only administrator can write templates"),
+		})
+		return false
+	}
+	return true
+}
+
+// Displays the editor with the given text, written in the given format.
+func showTemplateEditor(ctx *gin.Context, status int, text string, format authoring.Format, problems []string, message string) {
+	ctx.HTML(status, "template-editor.html", gin.H{
+		"Title":          "Template Editor",
+		"text":           text,
+		"format":         string(format),
+		"problems":       problems,
+		"message":        message,
+		"username":       "admin",
+		"loggedinstatus": true,
+		"viewas":         ctx.GetString("viewas"),
+		"canupload":      api.CanUploadTemplates(),
+	})
+}
+
+// Displays the editor, starting from an example template.
+// With start=viewed, starts instead from the simulation of the user the admin is viewing.
+// The format parameter chooses JSON or YAML; YAML is the default.
+func AdminTemplateEditor(ctx *gin.Context) {
+	if !templateEditorAllowed(ctx) {
+		return
+	}
+	format := authoring.ParseFormat(ctx.Query("format"))
+	def := authoring.Example()
+	if subject := ctx.GetString("viewas"); subject != "" && ctx.Query("start") == "viewed" {
+		key := models.ViewKey("admin", subject)
+		user := models.User(key)
+		sim := get_current_simulation(key)
+		if sim.Id == 0 {
+			showTemplateEditor(ctx, http.StatusOK, "", format, nil, fmt.Sprintf("%s has no simulation to start from", subject))
+			return
+		}
+		def = authoring.FromSimulation(sim, user.CommodityList, user.IndustryList, user.ClassList, user.IndustryStockList, user.ClassStockList)
+		def.Name = "Copy of " + def.Name
+		// The copy is all we need from the view. Stop viewing, so that
+		// the editor's buttons are not refused by ReadOnlyGuard.
+		stopViewing("admin")
+		ctx.Set("viewas", "")
+	}
+	text, err := authoring.Encode(def, format)
+	if err != nil {
+		showTemplateEditor(ctx, http.StatusInternalServerError, "", format, []string{err.Error()}, "")
+		return
+	}
+	showTemplateEditor(ctx, http.StatusOK, string(text), format, nil, "")
+}
+
+// Services the buttons of the editor.
+// The text is always read in the format it was written in. Then, depending on the button,
+// it is checked, converted to the other format, downloaded, or sent to the server.
+// Only a template with no problems is sent to the server.
+func AdminTemplateEdit(ctx *gin.Context) {
+	if !templateEditorAllowed(ctx) {
+		return
+	}
+	text := ctx.PostForm("text")
+	format := authoring.ParseFormat(ctx.PostForm("format"))
+	def, err := authoring.Decode([]byte(text), format)
+	if err != nil {
+		showTemplateEditor(ctx, http.StatusBadRequest, text, format, []string{err.Error()}, "")
+		return
+	}
+	problems := def.Validate()
+
+	switch action := ctx.PostForm("action"); action {
+	case "json", "yaml":
+		converted, err := authoring.Encode(def, authoring.ParseFormat(action))
+		if err != nil {
+			showTemplateEditor(ctx, http.StatusInternalServerError, text, format, []string{err.Error()}, "")
+			return
+		}
+		showTemplateEditor(ctx, http.StatusOK, string(converted), authoring.ParseFormat(action), problems, "")
+
+	case "download":
+		encoded, err := authoring.Encode(def, format)
+		if err != nil {
+			showTemplateEditor(ctx, http.StatusInternalServerError, text, format, []string{err.Error()}, "")
+			return
+		}
+		ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, templateFileName(def.Name), format))
+		ctx.Data(http.StatusOK, format.ContentType(), encoded)
+
+	case "upload":
+		if len(problems) > 0 {
+			showTemplateEditor(ctx, http.StatusBadRequest, text, format, problems, "Please put these problems right before sending the template to the server")
+			return
+		}
+		started := time.Now()
+		err := api.UploadTemplate(ctx.Request.Context(), def)
+		audit.Record("admin", 0, "template", err, time.Since(started), def.Name)
+		if err != nil {
+			logging.Warn("Could not create template", "name", def.Name, "error", err)
+			showTemplateEditor(ctx, http.StatusOK, text, format, nil, fmt.Sprintf("The server did not accept the template: %v", err))
+			return
+		}
+		showTemplateEditor(ctx, http.StatusOK, text, format, nil, fmt.Sprintf("Template %s created", def.Name))
+
+	default:
+		message := "No problems found"
+		if len(problems) > 0 {
+			message = ""
+		}
+		showTemplateEditor(ctx, http.StatusOK, text, format, problems, message)
+	}
+}
+
+// Loads a JSON or YAML file into the editor and checks it.
+// The format is taken from the file's extension.
+func AdminTemplateImport(ctx *gin.Context) {
+	if !templateEditorAllowed(ctx) {
+		return
+	}
+	header, err := ctx.FormFile("file")
+	if err != nil {
+		showTemplateEditor(ctx, http.StatusBadRequest, "", authoring.YAML, nil, "Please choose a file to import")
+		return
+	}
+	format := authoring.FormatOf(header.Filename)
+	file, err := header.Open()
+	if err != nil {
+		showTemplateEditor(ctx, http.StatusBadRequest, "", format, []string{err.Error()}, "")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxTemplateFileSize+1))
+	if err != nil || len(data) > maxTemplateFileSize {
+		showTemplateEditor(ctx, http.StatusBadRequest, "", format, nil, fmt.Sprintf("Could not read %s, or it is too large", header.Filename))
+		return
+	}
+	def, err := authoring.Decode(data, format)
+	if err != nil {
+		showTemplateEditor(ctx, http.StatusBadRequest, string(data), format, []string{err.Error()}, "")
+		return
+	}
+	showTemplateEditor(ctx, http.StatusOK, string(data), format, def.Validate(), "Imported "+header.Filename)
+}
+
+var unsafeFileCharacters = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// A file name made from a template's name
+func templateFileName(name string) string {
+	if safe := unsafeFileCharacters.ReplaceAllString(name, "_"); safe != "" && safe != "_" {
+		return safe
+	}
+	return "template"
+}
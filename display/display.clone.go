@@ -0,0 +1,138 @@
+// display.clone.go
+// the form that lets a user change a template's parameters before cloning it
+
+package display
+
+import (
+	"capfront/api"
+	"capfront/logging"
+	"capfront/models"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Finds the template with the given id, or returns nil
+func findTemplate(template_id string) *models.Simulation {
+	id, err := strconv.Atoi(template_id)
+	if err != nil {
+		return nil
+	}
+	templates := api.Templates.Get()
+	for i := range templates {
+		if templates[i].Id == id {
+			return &templates[i]
+		}
+	}
+	return nil
+}
+
+// The page data for the clone form
+func cloneForm(ctx *gin.Context, template *models.Simulation, parameters models.SimulationParameters) gin.H {
+	username, loginStatus, _ := userStatus(ctx)
+	return gin.H{
+		"Title":          "Clone " + template.Name,
+		"template":       template,
+		"parameters":     parameters,
+		"labouroptions":  withValue(models.LabourSupplyOptions(), template.Labour_Supply_Demand),
+		"priceoptions":   withValue(models.PriceResponseOptions(), template.Price_Response_Type),
+		"meltoptions":    withValue(models.MeltResponseOptions(), template.Melt_Response_Type),
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+	}
+}
+
+// helper function that makes sure the template's own value is one of the options offered,
+// so that the form never silently replaces it with the first option
+func withValue(options []string, value string) []string {
+	if value == "" || slices.Contains(options, value) {
+		return options
+	}
+	return append(options, value)
+}
+
+// Reads the parameters submitted with the clone form.
+// A number that can't be read is reported as a fault in the same way as one that is out of range.
+func parametersFromForm(ctx *gin.Context) (models.SimulationParameters, map[string]string) {
+	unreadable := make(map[string]string)
+	number := func(name string) float32 {
+		f, err := strconv.ParseFloat(ctx.PostForm(name), 32)
+		if err != nil {
+			unreadable[name] = "Please enter a number"
+		}
+		return float32(f)
+	}
+	parameters := models.SimulationParameters{
+		Periods_Per_Year:       number("periods_per_year"),
+		Population_Growth_Rate: number("population_growth_rate"),
+		Investment_Ratio:       number("investment_ratio"),
+		Labour_Supply_Demand:   ctx.PostForm("labour_supply_response"),
+		Price_Response_Type:    ctx.PostForm("price_response_type"),
+		Melt_Response_Type:     ctx.PostForm("melt_response_type"),
+		Currency_Symbol:        ctx.PostForm("currency_symbol"),
+		Quantity_Symbol:        ctx.PostForm("quantity_symbol"),
+	}
+	faults := parameters.Validate()
+	for name, message := range unreadable {
+		faults[name] = message
+	}
+	return parameters, faults
+}
+
+// Displays the clone form for the template specified by the 'id' parameter,
+// filled in with the template's own parameters.
+func CaptureCloneRequest(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	template := findTemplate(ctx.Param("id"))
+	if template == nil {
+		ctx.HTML(http.StatusNotFound, "errors.html", gin.H{"message": "There is no template with this id"})
+		return
+	}
+	if refuseOverQuota(ctx, models.User(username)) {
+		return
+	}
+	ctx.HTML(http.StatusOK, "clone.html", cloneForm(ctx, template, template.Parameters()))
+}
+
+// Service the clone form.
+// If any parameter is faulty, the form is shown again with the user's entries and the faults.
+// Otherwise the template is cloned and the server is told to use the user's parameters
+// (or, if it does not know how, the user is told that they were not used: see cloneTemplate).
+// If the user changed nothing, this is an ordinary clone.
+func HandleCloneRequest(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	template_id := ctx.Param("id")
+	template := findTemplate(template_id)
+	if template == nil {
+		ctx.HTML(http.StatusNotFound, "errors.html", gin.H{"message": "There is no template with this id"})
+		return
+	}
+	if refuseOverQuota(ctx, models.User(username)) {
+		return
+	}
+
+	parameters, faults := parametersFromForm(ctx)
+	if len(faults) > 0 {
+		page := cloneForm(ctx, template, parameters)
+		page["errors"] = faults
+		ctx.HTML(http.StatusBadRequest, "clone.html", page)
+		return
+	}
+	if parameters == template.Parameters() {
+		cloneTemplate(ctx, username, template_id, nil)
+		return
+	}
+	logging.Info("Cloning template with changed parameters", "user", username, "template", template_id)
+	cloneTemplate(ctx, username, template_id, &parameters)
+}
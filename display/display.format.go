@@ -0,0 +1,121 @@
+// display.format.go
+// functions that format numbers for display in the templates.
+// Used, for example, as {{ .Profit | money $.simulation }}
+
+package display
+
+import (
+	"capfront/config"
+	"capfront/models"
+	"html"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Formats a size, followed by the simulation's quantity symbol
+func formatQuantity(simulation models.Simulation, value any) template.HTML {
+	return markNegative(toFloat(value), "", suffix(simulation.Quantity_Symbol), 1)
+}
+
+// Formats a value or price, preceded by the simulation's currency symbol
+func formatMoney(simulation models.Simulation, value any) template.HTML {
+	return markNegative(toFloat(value), simulation.Currency_Symbol, "", 1)
+}
+
+// Formats a ratio (such as a profit rate) as a percentage, so 0.25 is shown as 25%
+func formatPercent(value any) template.HTML {
+	return markNegative(toFloat(value), "", "%", 100)
+}
+
+// Formats a plain number
+func formatNumber(value any) template.HTML {
+	return markNegative(toFloat(value), "", "", 1)
+}
+
+// helper function to put a space before a non-empty suffix
+func suffix(symbol string) string {
+	if symbol == "" {
+		return ""
+	}
+	return " " + symbol
+}
+
+// helper function that formats value*scale between prefix and suffix.
+// Negative values are wrapped in a span of class 'negative' so they stand out.
+func markNegative(value float64, prefix string, suffix string, scale float64) template.HTML {
+	scaled := value * scale
+	text := html.EscapeString(prefix) + FormatSignificant(math.Abs(scaled), config.SignificantDigits, config.ThousandsSeparator) + html.EscapeString(suffix)
+	if scaled < 0 && FormatSignificant(-scaled, config.SignificantDigits, "") != "0" {
+		return template.HTML(`<span class="negative">-` + text + `</span>`)
+	}
+	return template.HTML(text)
+}
+
+// Formats value with at least the given number of significant digits, with
+// separator between each group of three digits before the decimal point.
+// Digits before the decimal point are never dropped, so 12345.6 with 3
+// significant digits is "12,346". Trailing zeros after the point are removed,
+// so 0.30000001 with 4 significant digits is "0.3".
+func FormatSignificant(value float64, digits int, separator string) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	decimals := 0
+	if value != 0 {
+		decimals = digits - 1 - int(math.Floor(math.Log10(math.Abs(value))))
+	}
+	if decimals < 0 {
+		decimals = 0
+	}
+	text := strconv.FormatFloat(value, 'f', decimals, 64)
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	if text == "-0" {
+		text = "0"
+	}
+	return groupThousands(text, separator)
+}
+
+// helper function to insert separator between groups of three digits in the integer part of text
+func groupThousands(text string, separator string) string {
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	integer, fraction, hasFraction := strings.Cut(text, ".")
+	if separator != "" {
+		var grouped strings.Builder
+		for i, digit := range integer {
+			if i > 0 && (len(integer)-i)%3 == 0 {
+				grouped.WriteString(separator)
+			}
+			grouped.WriteRune(digit)
+		}
+		integer = grouped.String()
+	}
+	if hasFraction {
+		return sign + integer + "." + fraction
+	}
+	return sign + integer
+}
+
+// helper function to convert whatever number the template supplies into a float64
+func toFloat(value any) float64 {
+	switch v := value.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	default:
+		return math.NaN()
+	}
+}
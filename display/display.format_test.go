@@ -0,0 +1,162 @@
+// display.format_test.go
+// tests of the functions that format numbers and pictures for the templates
+
+package display
+
+import (
+	"capfront/config"
+	"capfront/models"
+	"html/template"
+	"math"
+	"testing"
+	"testing/fstest"
+)
+
+// sets the display settings that the formatters read, restoring them when the test ends
+func useFormatSettings(t *testing.T, digits int, separator string) {
+	oldDigits, oldSeparator := config.SignificantDigits, config.ThousandsSeparator
+	config.SignificantDigits, config.ThousandsSeparator = digits, separator
+	t.Cleanup(func() {
+		config.SignificantDigits, config.ThousandsSeparator = oldDigits, oldSeparator
+	})
+}
+
+func TestFormatSignificant(t *testing.T) {
+	tests := []struct {
+		value     float64
+		digits    int
+		separator string
+		want      string
+	}{
+		{0, 4, ",", "0"},
+		{1, 4, ",", "1"},
+		{0.30000001, 4, ",", "0.3"},
+		{0.0012345, 3, ",", "0.00123"},
+		{999, 4, ",", "999"},
+		{1000, 4, ",", "1,000"},
+		{12345.6, 3, ",", "12,346"},
+		{1234567.891, 4, ",", "1,234,568"},
+		{1234567, 4, "", "1234567"},
+		{1234567, 4, " ", "1 234 567"},
+		{-1234.6, 4, ",", "-1,235"},
+		{-0.00001, 2, ",", "-0.00001"},
+		{math.Inf(1), 4, ",", "+Inf"},
+	}
+	for _, test := range tests {
+		got := FormatSignificant(test.value, test.digits, test.separator)
+		if got != test.want {
+			t.Errorf("FormatSignificant(%v, %d, %q) = %q, want %q", test.value, test.digits, test.separator, got, test.want)
+		}
+	}
+}
+
+func TestFormatQuantity(t *testing.T) {
+	useFormatSettings(t, 4, ",")
+	simulation := models.Simulation{Quantity_Symbol: "kg"}
+	tests := []struct {
+		value any
+		want  template.HTML
+	}{
+		{float32(0), "0 kg"},
+		{float32(2.5), "2.5 kg"},
+		{12345, "12,345 kg"},
+		{float64(-1500), `<span class="negative">-1,500 kg</span>`},
+		{int64(1000000), "1,000,000 kg"},
+	}
+	for _, test := range tests {
+		if got := formatQuantity(simulation, test.value); got != test.want {
+			t.Errorf("formatQuantity(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+	if got := formatQuantity(models.Simulation{}, 3); got != "3" {
+		t.Errorf("with no quantity symbol, formatQuantity(3) = %q, want \"3\"", got)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	useFormatSettings(t, 4, ",")
+	simulation := models.Simulation{Currency_Symbol: "$"}
+	tests := []struct {
+		value any
+		want  template.HTML
+	}{
+		{float32(0), "$0"},
+		{math.Copysign(0, -1), "$0"},
+		{float64(1234.6), "$1,235"},
+		{float64(-250), `<span class="negative">-$250</span>`},
+		{int32(7), "$7"},
+	}
+	for _, test := range tests {
+		if got := formatMoney(simulation, test.value); got != test.want {
+			t.Errorf("formatMoney(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+	if got := formatMoney(models.Simulation{Currency_Symbol: "<b>"}, 1); got != "&lt;b&gt;1" {
+		t.Errorf("the currency symbol was not escaped: %q", got)
+	}
+}
+
+func TestFormatPercent(t *testing.T) {
+	useFormatSettings(t, 3, ",")
+	tests := []struct {
+		value any
+		want  template.HTML
+	}{
+		{float32(0), "0%"},
+		{float64(0.25), "25%"},
+		{float64(0.12345), "12.3%"},
+		{float64(12.5), "1,250%"},
+		{float64(-0.1), `<span class="negative">-10%</span>`},
+	}
+	for _, test := range tests {
+		if got := formatPercent(test.value); got != test.want {
+			t.Errorf("formatPercent(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	useFormatSettings(t, 4, ".")
+	tests := []struct {
+		value any
+		want  template.HTML
+	}{
+		{0, "0"},
+		{float32(1.5), "1.5"},
+		{float64(98765.4), "98.765"},
+		{-42, `<span class="negative">-42</span>`},
+		{"not a number", "NaN"},
+	}
+	for _, test := range tests {
+		if got := formatNumber(test.value); got != test.want {
+			t.Errorf("formatNumber(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCommodityImage(t *testing.T) {
+	oldFiles := StaticFiles
+	t.Cleanup(func() { StaticFiles = oldFiles })
+	StaticFiles = fstest.MapFS{
+		"images/commodities/corn.svg": &fstest.MapFile{Data: []byte("<svg/>")},
+	}
+	tests := []struct {
+		imageName string
+		want      string
+	}{
+		{"corn.svg", "/static/images/commodities/corn.svg"},
+		{"iron.svg", "/static/" + defaultCommodityImage},
+		{"", "/static/" + defaultCommodityImage},
+		{"../../../go.mod", "/static/" + defaultCommodityImage},
+		{"../commodities/corn.svg", "/static/" + defaultCommodityImage},
+	}
+	for _, test := range tests {
+		if got := commodityImage(test.imageName); got != test.want {
+			t.Errorf("commodityImage(%q) = %q, want %q", test.imageName, got, test.want)
+		}
+	}
+	StaticFiles = nil
+	if got := commodityImage("corn.svg"); got != "/static/"+defaultCommodityImage {
+		t.Errorf("with no static files, commodityImage = %q, want the default", got)
+	}
+}
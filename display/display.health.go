@@ -0,0 +1,65 @@
+// display.health.go
+// handlers that report whether this frontend is alive and whether it can serve pages
+
+package display
+
+import (
+	"capfront/api"
+	"capfront/models"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// True once the admin has logged in to the backend and the templates have been loaded.
+// Until then, pages that need the backend display serverdown.html.
+var backendReady atomic.Bool
+
+// Records whether the frontend has made contact with the backend
+func SetBackendReady(ready bool) {
+	backendReady.Store(ready)
+}
+
+// Reports whether the frontend has made contact with the backend
+func BackendReady() bool {
+	return backendReady.Load()
+}
+
+// Liveness: if this responds at all, the process is alive.
+func Healthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// Readiness: the frontend can serve pages if it has made contact with the backend,
+// has loaded the templates, and the backend is reachable right now.
+func Readyz(ctx *gin.Context) {
+	checks := gin.H{
+		"connected":         BackendReady(),
+		"templates_loaded":  len(models.Templates()) > 0,
+		"backend_reachable": true,
+	}
+	if err := api.Ping(time.Second); err != nil {
+		checks["backend_reachable"] = false
+		checks["backend_error"] = err.Error()
+	}
+	status := http.StatusOK
+	if !checks["connected"].(bool) || !checks["templates_loaded"].(bool) || !checks["backend_reachable"].(bool) {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, checks)
+}
+
+// Middleware for pages that cannot work without the backend.
+// Until the frontend has made contact with it, displays serverdown.html instead.
+func RequireBackend(ctx *gin.Context) {
+	if !BackendReady() {
+		ctx.HTML(http.StatusServiceUnavailable, "serverdown.html", gin.H{
+			"Title": "Server unavailable",
+		})
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
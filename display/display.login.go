@@ -5,17 +5,21 @@ package display
 
 import (
 	"capfront/api"
+	"capfront/audit"
 	"capfront/auth"
+	"capfront/logging"
+	"capfront/metrics"
 	"capfront/models"
+	"capfront/persist"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"runtime"
+	"net/url"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
@@ -45,6 +49,37 @@ var excuses = map[string]apology{
 
 var userMessage string
 
+// Returned by ServerLogin when the server refused the username or password,
+// as opposed to when the server could not be reached or misbehaved.
+// Only refusals count towards throttling and lockout.
+var errLoginRejected = errors.New("login failed")
+
+// helper function to obtain the status of a response that may not exist
+func statusOf(res *http.Response) int {
+	if res == nil {
+		return 0
+	}
+	return res.StatusCode
+}
+
+// Extracts the username and password from a submitted login or registration form.
+// Returns an error, suitable for showing to the user, if either is missing or too long.
+func readCredentials(ctx *gin.Context) (string, string, error) {
+	username := strings.TrimSpace(ctx.PostForm("username"))
+	password := ctx.PostForm("password")
+	switch {
+	case username == "":
+		return "", "", errors.New("please supply a username")
+	case password == "":
+		return "", "", errors.New("please supply a password")
+	case utf8.RuneCountInString(username) > auth.MaxUsernameLength:
+		return "", "", fmt.Errorf("the username can be at most %d characters long", auth.MaxUsernameLength)
+	case utf8.RuneCountInString(password) > auth.MaxPasswordLength:
+		return "", "", fmt.Errorf("the password can be at most %d characters long", auth.MaxPasswordLength)
+	}
+	return username, password, nil
+}
+
 // Displays a form to capture the user request to log in.
 // The form specifies only one action, which is a submit button that POSTS the user name and password.
 // This POST is handled by `ClientLoginRequest`.
@@ -56,25 +91,51 @@ func CaptureLoginRequest(ctx *gin.Context) {
 
 // Service the form submitted when a user logs in.
 // Because of the setup, it merely passes the request to the backend server
+// Repeated failures from the same address, or for the same username, are throttled
+// by auth.LoginLimiter and eventually locked out for a while.
 func HandleLoginRequest(ctx *gin.Context) {
-	clientRequest := ctx.Request
-	clientRequest.ParseForm()
-	username := clientRequest.Form["username"][0]
-	password := clientRequest.Form["password"][0]
-	serverPayload, err := ServerLogin(username, password)
+	username, password, err := readCredentials(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusBadRequest, "login.html", gin.H{
+			"message": "Could not log you in",
+			"advice":  err.Error(),
+		})
+		return
+	}
 
-	// TODO diagnostics only - delete in production version.
-	fmt.Printf("HandleLoginRequest was called")
-	_, file, no, ok := runtime.Caller(1)
-	if ok {
-		fmt.Printf("HandleLoginRequest was called from %s#%d\n", file, no)
-	} else {
-		fmt.Printf("Could not diagnose where login request was called from")
+	limiterKeys := []string{"ip:" + ctx.ClientIP(), "user:" + username}
+	for _, key := range limiterKeys {
+		if allowed, wait := auth.LoginLimiter.Allow(key); !allowed {
+			logging.Warn("Login attempt throttled", "user", username, "key", key, "wait", wait)
+			audit.RecordEntry(audit.Entry{Time: time.Now().UTC(), User: username, Action: "login", Outcome: "throttled", Detail: key})
+			ctx.HTML(http.StatusTooManyRequests, "login.html", gin.H{
+				"message": "Too many failed attempts to log in",
+				"advice":  fmt.Sprintf("Please wait %v and try again", wait.Round(time.Second)),
+			})
+			return
+		}
+	}
+
+	// The browser may belong to someone else, so SerialiseUser may not hold this user's lock
+	unlock := func() {}
+	if _, ok := models.LookupUser(username); ok {
+		unlock = lockOther(ctx, username)
+	}
+	defer unlock()
+
+	started := time.Now()
+	serverPayload, err := ServerLogin(username, password)
+	audit.Record(username, 0, "login", err, time.Since(started), "")
+	for _, key := range limiterKeys {
+		if err == nil {
+			auth.LoginLimiter.Succeed(key)
+		} else if errors.Is(err, errLoginRejected) {
+			auth.LoginLimiter.Fail(key)
+		}
 	}
 
 	if err != nil { // something went wrong; tell the developer and tell the user
-		message := fmt.Sprintf("%s", serverPayload["message"])
-		log.Output(1, message)
+		logging.Warn("Login failed", "user", username, "reason", serverPayload["message"])
 		ctx.HTML(http.StatusOK, "login.html", gin.H{
 			"message": "Could not log you in",
 			"advice":  "Please try again",
@@ -86,20 +147,22 @@ func HandleLoginRequest(ctx *gin.Context) {
 	// register the user name as a cookie in the user browser
 	// TODO fix up SameSite, wrong domain error, etc
 	ctx.SetCookie("User", username, 34560000, "/", auth.APISOURCE, false, false)
-	api.Refresh(ctx, username) // refresh the user's tables from the server at first login
+	api.Refresh(ctx.Request.Context(), username) // refresh the user's tables from the server at first login
 
 	// Refresh user status from the server (which simulations we are using, etc)
 	// TODO remove silly confusion between client URL 'user/' and server URL 'users/'
-	body, _ := auth.ProtectedResourceServerRequest(username, " get user details ", `users/`+username)
-	jsonErr := json.Unmarshal(body, &models.UserServerItem)
+	body, _ := auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, " get user details ", `users/`+username)
+	var serverItem models.UserServerData
+	jsonErr := json.Unmarshal(body, &serverItem)
 
 	if jsonErr != nil { // We couldn't understand the server's response
 		// TODO display the error standardly as above and logout
-		log.Output(1, "Failed to obtain user details for logged in user - cannot set current simulation right now")
+		logging.Warn("Failed to obtain user details for logged in user - cannot set current simulation right now", "user", username)
 	} else {
-		log.Output(1, fmt.Sprintf("Setting current simulation to be %d", models.UserServerItem.CurrentSimulation))
-		models.Users[username].CurrentSimulation = models.UserServerItem.CurrentSimulation
+		logging.Debug("Setting current simulation", "user", username, "simulation", serverItem.CurrentSimulation)
+		models.User(username).CurrentSimulation = serverItem.CurrentSimulation
 	}
+	persist.SaveUser(models.User(username))
 
 	// display the appropriate dashboard.
 	if username == "admin" {
@@ -120,7 +183,7 @@ func HandleLoginRequest(ctx *gin.Context) {
 func ServerLogin(username string, password string) (gin.H, error) {
 	apiUrl := auth.APISOURCE + `auth/login`
 	client := http.Client{Timeout: time.Second * 2}
-	serverpayload := `username=` + username + `&password=` + password
+	serverpayload := url.Values{"username": {username}, "password": {password}}.Encode()
 	var serverRequest *http.Request
 	serverRequest, err := http.NewRequest(http.MethodPost, apiUrl, strings.NewReader(serverpayload))
 	if err != nil {
@@ -128,13 +191,15 @@ func ServerLogin(username string, password string) (gin.H, error) {
 	}
 	serverRequest.Header.Set("Authorization", "Basic Og==")
 	serverRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	started := time.Now()
 	res, err := client.Do(serverRequest)
+	metrics.ObserveBackend(`auth/login`, started, err, statusOf(res))
 	if err != nil {
 		return map[string]any{"loggedinstatus": false, "message": excuses["server"].apologize(err)}, errors.New("login failed")
 	}
 
 	if res.StatusCode != 200 {
-		return gin.H{"loggedinstatus": false, "message": excuses["rejected"].apologize(err)}, errors.New("login failed")
+		return gin.H{"loggedinstatus": false, "message": excuses["rejected"].apologize(err)}, errLoginRejected
 	}
 	defer res.Body.Close()
 
@@ -150,9 +215,9 @@ func ServerLogin(username string, password string) (gin.H, error) {
 	}
 
 	accessToken := target["access_token"]
-	log.Output(1, fmt.Sprintf(" Logged in user %s \n", username))
-	auth.PrintUsers() // Comment in for extended diagnostics
-	userDetails := models.Users[username]
+	logging.Info("Logged in user", "user", username)
+	auth.PrintUsers() // Only visible at debug level
+	userDetails := models.User(username)
 	userDetails.Token = accessToken
 	userDetails.LoggedIn = true // TODO think about cookie expiry and refresh
 	return gin.H{"loggedinstatus": true, "message": fmt.Sprintf("Logged in user %s\n", username)}, nil
@@ -167,10 +232,18 @@ func ClientLogoutRequest(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, fmt.Sprintf("Failed to log out because: %v", err))
 		return
 	}
-	userDetails := models.Users[username]
-	auth.ProtectedResourceServerRequest(username, "Log out", `auth/logout`)
+	userDetails, ok := models.LookupUser(username)
+	if !ok {
+		ctx.JSON(http.StatusOK, fmt.Sprintf("Failed to log out because we don't know user %s", username))
+		return
+	}
+	started := time.Now()
+	_, err = auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, "Log out", `auth/logout`)
+	audit.Record(username, userDetails.CurrentSimulation, "logout", err, time.Since(started), "")
 	userDetails.Token = "invalid token"
 	userDetails.LoggedIn = false // TODO think about cookie expiry and refresh
+	stopViewing(username)
+	persist.SaveUser(userDetails)
 	CaptureLoginRequest(ctx)
 }
 
@@ -181,21 +254,27 @@ func CaptureRegisterRequest(ctx *gin.Context) {
 
 // Service the form submitted when a user registers.
 // Because of the setup, it merely passes the request to the backend server
+// The username and password are checked against the rules in auth.ValidateRegistration
+// before anything is sent; if they break the rules, the form is redisplayed with
+// a message beside each faulty field.
 func HandleRegisterRequest(ctx *gin.Context) {
-	clientRequest := ctx.Request
-	clientRequest.ParseForm()
-	username := clientRequest.Form["username"][0]
-	password := clientRequest.Form["password"][0]
+	username := strings.TrimSpace(ctx.PostForm("username"))
+	password := ctx.PostForm("password")
+	faults := auth.ValidateRegistration(username, password, ctx.PostForm("confirm"))
+	if len(faults) > 0 {
+		ctx.HTML(http.StatusBadRequest, "register.html", gin.H{
+			"errors":  faults,
+			"entered": username,
+		})
+		return
+	}
 	serverPayload, err := ServerRegister(username, password) // Ask the server to do the heavy lifting
 
 	if err != nil { // something went wrong; tell the developer and tell the user
-		message := fmt.Sprintf("%s", serverPayload["message"])
-		log.Output(1, message)
-		ctx.HTML(serverPayload["StatusCode"].(int), "login.html", gin.H{
-			"message": "The server didn't like this",
-			"advice":  "Please report this",
-			"info":    "Or ask me to register you",
-			"error":   err,
+		logging.Warn("Registration failed", "user", username, "reason", serverPayload["message"])
+		ctx.HTML(http.StatusOK, "register.html", gin.H{
+			"message": "The server didn't like this. Perhaps that username is taken?",
+			"entered": username,
 		})
 		return
 	}
@@ -214,15 +293,17 @@ func HandleRegisterRequest(ctx *gin.Context) {
 func ServerRegister(username string, password string) (gin.H, error) {
 	apiUrl := auth.APISOURCE + `auth/register`
 	client := http.Client{Timeout: time.Second * 2}
-	serverpayload := `username=` + username + `&password=` + password
+	serverpayload := url.Values{"username": {username}, "password": {password}}.Encode()
 	serverRequest, err := http.NewRequest(http.MethodPost, apiUrl, strings.NewReader(serverpayload))
 	if err != nil {
 		return map[string]any{"loggedinstatus": false, "message": excuses["client"].apologize(err)}, errors.New("registration failed")
 	}
 	serverRequest.Header.Set("Authorization", "Basic Og==")
 	serverRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	print("Sending register request to server\n", serverpayload)
+	logging.Debug("Sending registration request to server", "user", username)
+	started := time.Now()
 	res, err := client.Do(serverRequest)
+	metrics.ObserveBackend(`auth/register`, started, err, statusOf(res))
 	if err != nil {
 		return map[string]any{"loggedinstatus": false, "message": excuses["server"].apologize(err)}, fmt.Errorf("error%v", err)
 	}
@@ -243,18 +324,82 @@ func ServerRegister(username string, password string) (gin.H, error) {
 		return gin.H{"loggedinstatus": false, "message": excuses["comms"].apologize(err)}, errors.New("registration failed")
 	}
 
-	log.Output(1, fmt.Sprintf(" The server response was %v with status code %d", target.Message, target.StatusCode))
+	logging.Debug("Server responded to registration request", "user", username, "message", target.Message, "status", target.StatusCode)
 
 	if target.StatusCode != 200 {
-		log.Output(1, fmt.Sprintf(" Could not register user %s because the server said '%s'\n", username, target.Message))
+		logging.Warn("Server refused to register user", "user", username, "message", target.Message)
 		return gin.H{"loggedinstatus": false, "message": excuses["rejected"].apologize(err)}, errors.New("registration request rejected")
 	}
 
-	log.Output(1, fmt.Sprintf(" Registered user %s \n", username))
+	logging.Info("Registered user", "user", username)
 
 	// add the user to our local database, flagged as not logged in and with empty token.
 	// server will do the same so this is just a mirror of the server entry.
 	new_user := models.UserData{LoggedIn: false, UserName: username, Token: ""}
-	models.Users[username] = &new_user
+	models.AddUser(&new_user)
 	return gin.H{"message": "Registration succeeded. Please log in"}, nil
 }
+
+// Displays a form in which a logged-in user can change their password.
+func CapturePasswordChangeRequest(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	ctx.HTML(http.StatusOK, "password.html", gin.H{
+		"Title":          "Change Password",
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+	})
+}
+
+// Service the form submitted when a user changes their password.
+// The new password is checked against the same rules as at registration
+// and then passed to the backend, which checks the old one.
+func HandlePasswordChangeRequest(ctx *gin.Context) {
+	_, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	// The password belongs to whoever is logged in, never to a user the admin is viewing
+	username, err := auth.Get_current_user(ctx)
+	if err != nil {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	page := gin.H{
+		"Title":          "Change Password",
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+	}
+
+	oldPassword := ctx.PostForm("old_password")
+	newPassword := ctx.PostForm("password")
+	faults := auth.ValidateNewPassword(newPassword, ctx.PostForm("confirm"))
+	if oldPassword == "" {
+		faults["old_password"] = "Please supply your current password"
+	}
+	if len(faults) > 0 {
+		page["errors"] = faults
+		ctx.HTML(http.StatusBadRequest, "password.html", page)
+		return
+	}
+
+	form := url.Values{"old_password": {oldPassword}, "new_password": {newPassword}}
+	_, err = auth.ProtectedFormServerRequest(ctx.Request.Context(), username, "change password", `auth/password`, form)
+	if err != nil {
+		logging.Warn("Could not change password", "user", username, "error", err)
+		page["message"] = "The server would not change your password. Please check your current password and try again."
+		ctx.HTML(http.StatusOK, "password.html", page)
+		return
+	}
+
+	logging.Info("User changed their password", "user", username)
+	page["message"] = "Your password has been changed"
+	ctx.HTML(http.StatusOK, "password.html", page)
+}
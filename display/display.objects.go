@@ -5,14 +5,15 @@ package display
 
 import (
 	"capfront/api"
+	"capfront/audit"
 	"capfront/auth"
+	"capfront/logging"
 	"capfront/models"
+	"capfront/sweep"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
-	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,27 +25,20 @@ import (
 func userStatus(ctx *gin.Context) (string, bool, error) {
 	var loginStatus bool = false
 
-	// Diagnostics - find out who called us
-
-	_, file, no, ok := runtime.Caller(1)
-	if ok {
-		fmt.Printf("userStatus was called from %s#%d\n", file, no)
-	}
-
 	// find out what the browser knows
 
 	username, err := auth.Get_current_user(ctx)
 	if err != nil {
-		log.Printf("The client browser knows nothing about user %s", username)
+		logging.Debug("The client browser knows nothing about this user", "path", ctx.Request.URL.Path)
 		return "unknown", false, err
 	}
 
 	// find out what the server knows
 
 	synched_user := models.UserServerData{}
-	body, err := auth.ProtectedResourceServerRequest(username, "Synchronise with server", `users/`+username)
+	body, err := auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, "Synchronise with server", `users/`+username)
 	if err != nil {
-		log.Printf("The server knows nothing about user %s", username)
+		logging.Warn("The server knows nothing about this user", "user", username)
 		return username, false, err
 	}
 
@@ -53,49 +47,124 @@ func userStatus(ctx *gin.Context) (string, bool, error) {
 	// the server knows something
 
 	if err != nil {
-		log.Printf("The server failed to inform us about user %s", username)
+		logging.Warn("The server failed to inform us about this user", "user", username, "error", err)
 		ctx.Redirect(http.StatusMovedPermanently, "/login")
 		// TODO tell the user why she is being asked to log in again
 		return username, false, err
 	}
 
 	// Ask the server whether it accepts that the user is logged in
-	log.Printf("The server knows about user %s - ask if we are logged in", username)
+	logging.Debug("The server knows about this user - ask if we are logged in", "user", username)
 
 	if !synched_user.Is_logged_in {
-		log.Printf("User %s is not logged in at the server", username)
+		logging.Info("User is not logged in at the server", "user", username)
 		ctx.Redirect(http.StatusMovedPermanently, "/login")
 		// TODO tell the user why she is being asked to log in again
 		return username, false, err
 	}
 
-	log.Printf("The server knows about user %s and says it is logged in", username)
+	logging.Debug("The server says this user is logged in", "user", username)
 
 	// We agree with the server that this user can log in.
 	// Now synch with the server in case something changed
 	{
-		if models.Users[username].CurrentSimulation != synched_user.CurrentSimulation {
-			log.Printf("We are out of synch. Server thinks our simulation is %d and client says it is %d",
-				synched_user.CurrentSimulation,
-				models.Users[username].CurrentSimulation)
-			if !api.Refresh(ctx, username) {
-				log.Printf("We don't have a token. Redirecting to login")
+		// While a sweep runs, the server's current simulation is the sweep's, which the user must never see
+		if models.User(username).CurrentSimulation != synched_user.CurrentSimulation && !sweep.IsRunning(username) {
+			logging.Info("Out of synch with the server",
+				"user", username,
+				"serversimulation", synched_user.CurrentSimulation,
+				"clientsimulation", models.User(username).CurrentSimulation)
+			if !api.Refresh(ctx.Request.Context(), username) {
+				logging.Warn("We don't have a token. Redirecting to login", "user", username)
 				ctx.Redirect(http.StatusMovedPermanently, "/login")
 				return username, false, nil
 			}
+			// The server may name a simulation the user no longer has, such as the last one
+			// a sweep created and deleted. The server cannot be told otherwise, so keep ours.
+			if hasSimulation(models.User(username), synched_user.CurrentSimulation) {
+				models.User(username).CurrentSimulation = synched_user.CurrentSimulation
+			}
 		}
 
-		models.Users[username].LastVisitedPage = ctx.Request.URL.Path
-		models.Users[username].CurrentSimulation = synched_user.CurrentSimulation
-		loginStatus = models.Users[username].LoggedIn
+		// Messages are for the page the user asked for next, so start afresh. Handlers fill this in.
+		models.User(username).UserMessage = &models.UserMessage{StatusCode: http.StatusOK}
+		models.User(username).LastVisitedPage = ctx.Request.URL.Path
+		loginStatus = models.User(username).LoggedIn
+
+		// If the admin is viewing another user's simulation, display the copy of that user's tables instead.
+		if viewed := models.User(username).ViewAs; viewed != "" {
+			ctx.Set("viewas", viewed)
+			return models.ViewKey(username, viewed), loginStatus, err
+		}
 		return username, loginStatus, err
 	}
 }
 
+// Middleware that holds the lock on the data of the user making the request until the handler is done,
+// so that nothing working in the background (a sweep, a resync by the admin, the restore at startup)
+// changes it while the handler is using it. Requests from the same user are handled one at a time.
+func SerialiseUser(ctx *gin.Context) {
+	username, err := auth.Get_current_user(ctx)
+	if err == nil {
+		if _, ok := models.LookupUser(username); ok {
+			ctx.Set("lockeduser", username)
+			ctx.Set("unlockuser", models.LockUser(username))
+			defer func() { ctx.MustGet("unlockuser").(func())() }()
+		}
+	}
+	ctx.Next()
+}
+
+// helper function for handlers that change the data of a user other than the one making the request.
+// Locks the data of the user called name, unless SerialiseUser already holds that lock for this request.
+// Returns the function that unlocks it.
+//
+// Two users' locks are always taken in the order of their names, so that two requests, each
+// holding its own user's lock and wanting the other's, never wait for each other for ever.
+// If the name comes first, the lock SerialiseUser holds is let go while the other is taken,
+// and taken again straight after; another request from the same user may run in between.
+func lockOther(ctx *gin.Context, name string) func() {
+	held := ctx.GetString("lockeduser")
+	if held == name {
+		return func() {}
+	}
+	if held == "" || held < name {
+		return models.LockUser(name)
+	}
+	ctx.MustGet("unlockuser").(func())()
+	unlock := models.LockUser(name)
+	ctx.Set("unlockuser", models.LockUser(held))
+	return unlock
+}
+
+// helper function: true if the user has the simulation with the given id
+func hasSimulation(user *models.UserData, id int) bool {
+	for _, s := range user.SimulationList {
+		if s.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// helper function asking the server which simulation it takes to be the user's current one.
+// This is the simulation that the server's actions change.
+func serverCurrentSimulation(ctx *gin.Context, username string) (int, error) {
+	body, err := auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, " get user details ", `users/`+username)
+	if err != nil {
+		return 0, err
+	}
+	var serverItem models.UserServerData
+	if err := json.Unmarshal(body, &serverItem); err != nil {
+		return 0, err
+	}
+	return serverItem.CurrentSimulation, nil
+}
+
 // helper function to obtain the state of the current simulation
 // if no user is logged in, return null state
 func get_current_state(username string) string {
-	this_user := models.Users[username]
+	this_user := models.User(username)
 	if this_user == nil {
 		return "NO SIMULATION YET"
 	}
@@ -109,19 +178,35 @@ func get_current_state(username string) string {
 	return "UNKNOWN"
 }
 
+// helper function to obtain the current simulation itself
+// (used, for example, to find its currency symbol).
+// If there is none, returns an empty simulation.
+func get_current_simulation(username string) models.Simulation {
+	this_user := models.User(username)
+	if this_user == nil {
+		return models.Simulation{}
+	}
+	for i := 0; i < len(this_user.SimulationList); i++ {
+		if this_user.SimulationList[i].Id == this_user.CurrentSimulation {
+			return this_user.SimulationList[i]
+		}
+	}
+	return models.Simulation{}
+}
+
 // helper function to set the state of the current simulation
 // if we fail it's a programme error so we don't test for that
 func set_current_state(username string, new_state string) {
-	this_user := models.Users[username]
+	this_user := models.User(username)
 	this_simulation_id := this_user.CurrentSimulation
-	log.Output(1, fmt.Sprintf("resetting state to %s for user %s", new_state, this_user.UserName))
+	logging.Debug("Resetting state", "user", this_user.UserName, "state", new_state)
 	for i := 0; i < len(this_user.SimulationList); i++ {
 		s := &this_user.SimulationList[i]
 		if (*s).Id == this_simulation_id {
 			(*s).State = new_state
 			return
 		}
-		log.Output(1, fmt.Sprintf("simulation with id %d not found", this_simulation_id))
+		logging.Debug("Simulation not found", "user", this_user.UserName, "simulation", this_simulation_id)
 	}
 }
 
@@ -137,10 +222,13 @@ func ShowCommodities(ctx *gin.Context) {
 
 	ctx.HTML(http.StatusOK, "commodities.html", gin.H{
 		"Title":          "Commodities",
-		"commodities":    models.Users[username].CommodityList,
+		"commodities":    models.User(username).CommodityList,
 		"username":       username,
 		"loggedinstatus": loginStatus,
 		"state":          state,
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+		"whathappened":   whatHappened(ctx, username),
 	})
 }
 
@@ -155,10 +243,13 @@ func ShowIndustries(ctx *gin.Context) {
 	state := get_current_state(username)
 	ctx.HTML(http.StatusOK, "industries.html", gin.H{
 		"Title":          "Industries",
-		"industries":     models.Users[username].IndustryList,
+		"industries":     models.User(username).IndustryList,
 		"username":       username,
 		"loggedinstatus": loginStatus,
 		"state":          state,
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+		"whathappened":   whatHappened(ctx, username),
 	})
 }
 
@@ -172,10 +263,13 @@ func ShowClasses(ctx *gin.Context) {
 	state := get_current_state(username)
 	ctx.HTML(http.StatusOK, "classes.html", gin.H{
 		"Title":          "Classes",
-		"classes":        models.Users[username].ClassList,
+		"classes":        models.User(username).ClassList,
 		"username":       username,
 		"loggedinstatus": loginStatus,
 		"state":          state,
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+		"whathappened":   whatHappened(ctx, username),
 	})
 }
 
@@ -190,14 +284,16 @@ func ShowCommodity(ctx *gin.Context) {
 	state := get_current_state(username)
 	id, _ := strconv.Atoi(ctx.Param("id"))
 	// TODO here and elsewhere create a method to get the simulation
-	for i := 0; i < len(models.Users[username].CommodityList); i++ {
-		if id == models.Users[username].CommodityList[i].Id {
+	for i := 0; i < len(models.User(username).CommodityList); i++ {
+		if id == models.User(username).CommodityList[i].Id {
 			ctx.HTML(http.StatusOK, "commodity.html", gin.H{
 				"Title":          "Commodity",
-				"commodity":      models.Users[username].CommodityList[i],
+				"commodity":      models.User(username).CommodityList[i],
 				"username":       username,
 				"loggedinstatus": loginStatus,
 				"state":          state,
+				"viewas":         ctx.GetString("viewas"),
+				"simulation":     get_current_simulation(username),
 			})
 		}
 	}
@@ -214,14 +310,16 @@ func ShowIndustry(ctx *gin.Context) {
 	state := get_current_state(username)
 	id, _ := strconv.Atoi(ctx.Param("id")) //TODO check user didn't do something stupid
 	// TODO here and elsewhere create a method to get the simulation
-	for i := 0; i < len(models.Users[username].IndustryList); i++ {
-		if id == models.Users[username].IndustryList[i].Id {
+	for i := 0; i < len(models.User(username).IndustryList); i++ {
+		if id == models.User(username).IndustryList[i].Id {
 			ctx.HTML(http.StatusOK, "industry.html", gin.H{
 				"Title":          "Industry",
-				"industry":       models.Users[username].IndustryList[i],
+				"industry":       models.User(username).IndustryList[i],
 				"username":       username,
 				"loggedinstatus": loginStatus,
 				"state":          state,
+				"viewas":         ctx.GetString("viewas"),
+				"simulation":     get_current_simulation(username),
 			})
 		}
 	}
@@ -238,14 +336,16 @@ func ShowClass(ctx *gin.Context) {
 	state := get_current_state(username)
 	id, _ := strconv.Atoi(ctx.Param("id")) //TODO check user didn't do something stupid
 	// TODO here and elsewhere create a method to get the simulation
-	for i := 0; i < len(models.Users[username].ClassList); i++ {
-		if id == models.Users[username].ClassList[i].Id {
+	for i := 0; i < len(models.User(username).ClassList); i++ {
+		if id == models.User(username).ClassList[i].Id {
 			ctx.HTML(http.StatusOK, "class.html", gin.H{
 				"Title":          "Class",
-				"class":          models.Users[username].ClassList[i],
+				"class":          models.User(username).ClassList[i],
 				"username":       username,
 				"loggedinstatus": loginStatus,
 				"state":          state,
+				"viewas":         ctx.GetString("viewas"),
+				"simulation":     get_current_simulation(username),
 			})
 		}
 	}
@@ -254,7 +354,6 @@ func ShowClass(ctx *gin.Context) {
 // Displays snapshot of the economy
 // TODO parameterise the templates to reduce boilerplate
 func ShowIndexPage(ctx *gin.Context) {
-	fmt.Printf("Show Index Page was called")
 	username, loginStatus, _ := userStatus(ctx)
 	if !loginStatus {
 		ctx.Redirect(http.StatusMovedPermanently, "/login")
@@ -265,18 +364,27 @@ func ShowIndexPage(ctx *gin.Context) {
 	api.UserMessage = `This is the home page`
 	ctx.HTML(http.StatusOK, "index.html", gin.H{
 		"Title":          "Economy",
-		"industries":     models.Users[username].IndustryList,
-		"commodities":    models.Users[username].CommodityList,
-		"Message":        models.Users[username].UserMessage.Message,
+		"industries":     models.User(username).IndustryList,
+		"commodities":    models.User(username).CommodityList,
+		"Message":        models.User(username).UserMessage.Message,
 		"DisplayOptions": models.Quantity,
-		"classes":        models.Users[username].ClassList,
+		"classes":        models.User(username).ClassList,
 		"username":       username,
 		"loggedinstatus": loginStatus,
 		"state":          state,
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+		"whathappened":   whatHappened(ctx, username),
 	})
 }
 
 // Fetch the trace from the local database
+// and display it as an outline, grouped by time stamp.
+// The query parameters are
+//
+//	q      show only messages containing this text
+//	all    if 'yes', include the trace of all the user's simulations, not just the current one
+//	depth  expand the outline down to this level (default 2)
 func ShowTrace(ctx *gin.Context) {
 	username, loginStatus, _ := userStatus(ctx)
 	if !loginStatus {
@@ -284,16 +392,31 @@ func ShowTrace(ctx *gin.Context) {
 		return
 	}
 
+	user := models.User(username)
+	simulation := user.CurrentSimulation
+	if ctx.Query("all") == "yes" {
+		simulation = 0
+	}
+	depth, err := strconv.Atoi(ctx.DefaultQuery("depth", "2"))
+	if err != nil {
+		depth = 2
+	}
+
 	state := get_current_state(username)
 	ctx.HTML(
 		http.StatusOK,
 		"trace.html",
 		gin.H{
 			"Title":          "Simulation Trace",
-			"trace":          models.Users[username].TraceList,
+			"groups":         outlineTrace(user.TraceList, simulation, ctx.Query("q"), depth, newEntityLinks(user)),
+			"query":          ctx.Query("q"),
+			"all":            simulation == 0,
+			"depth":          depth,
 			"username":       username,
 			"loggedinstatus": loginStatus,
 			"state":          state,
+			"viewas":         ctx.GetString("viewas"),
+			"simulation":     get_current_simulation(username),
 		},
 	)
 }
@@ -301,13 +424,6 @@ func ShowTrace(ctx *gin.Context) {
 // Retrieve all templates, and all simulations belonging to this user, from the local database
 // Display them in the user dashboard
 func UserDashboard(ctx *gin.Context) {
-
-	// TODO Diagnostics only - probably remove this in production version.
-	_, file, no, ok := runtime.Caller(1)
-	if ok {
-		fmt.Printf("User Dashboard was called from %s#%d\n", file, no)
-	}
-
 	username, loginStatus, _ := userStatus(ctx)
 	if !loginStatus {
 		ctx.Redirect(http.StatusMovedPermanently, "/login")
@@ -317,23 +433,27 @@ func UserDashboard(ctx *gin.Context) {
 	state := get_current_state(username)
 	ctx.HTML(http.StatusOK, "user-dashboard.html", gin.H{
 		"Title":          "Dashboard",
-		"simulations":    models.Users[username].SimulationList,
-		"templates":      models.TemplateList,
+		"simulations":    models.User(username).SimulationList,
+		"templates":      api.Templates.Get(),
+		"quota":          models.User(username).Quota(),
+		"quotaexhausted": models.User(username).QuotaExhausted(),
 		"username":       username,
 		"loggedinstatus": loginStatus,
 		"state":          state,
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
 	})
 }
 
 // a diagnostic endpoint to display the data in the system
 func DataHandler(ctx *gin.Context) {
 	// username, loginStatus, _ := userStatus(ctx)
-	// b, err := json.Marshal(models.Users)
+	// b, err := json.Marshal(models.AllUsers())
 	// if err != nil {
 	// 	fmt.Println("Could not marshal the Users object")
 	// 	return
 	// }
-	ctx.JSON(http.StatusOK, models.Users)
+	ctx.JSON(http.StatusOK, models.AllUsers())
 }
 
 func SwitchSimulation(ctx *gin.Context) {
@@ -344,7 +464,7 @@ func SwitchSimulation(ctx *gin.Context) {
 	}
 
 	id, _ := strconv.Atoi(ctx.Param("id"))
-	log.Output(1, fmt.Sprintf("User %s wants to switch to simulation %d", username, id))
+	logging.Info("User wants to switch simulation", "user", username, "simulation", id)
 	ctx.HTML(http.StatusOK, "notready.html", gin.H{
 		"Title": "Not Ready",
 	})
@@ -358,9 +478,14 @@ func DeleteSimulation(ctx *gin.Context) {
 	}
 
 	id, _ := strconv.Atoi(ctx.Param("id"))
-	log.Output(1, fmt.Sprintf("User %s wants to delete simulation %d", username, id))
-	auth.ProtectedResourceServerRequest(username, "Delete simulation", "simulations/delete/"+ctx.Param("id"))
-	api.Refresh(ctx, username)
+	logging.Info("User wants to delete simulation", "user", username, "simulation", id)
+	started := time.Now()
+	_, err := auth.ProtectedResourceServerRequest(ctx.Request.Context(), username, "Delete simulation", "simulations/delete/"+ctx.Param("id"))
+	audit.Record(username, id, "delete", err, time.Since(started), "")
+	if err == nil {
+		models.User(username).ForgetLocalRun(id)
+	}
+	api.Refresh(ctx.Request.Context(), username)
 	UserDashboard(ctx)
 }
 
@@ -372,7 +497,7 @@ func RestartSimulation(ctx *gin.Context) {
 	}
 
 	id, _ := strconv.Atoi(ctx.Param("id"))
-	log.Output(1, fmt.Sprintf("User %s wants to restart simulation %d", username, id))
+	logging.Info("User wants to restart simulation", "user", username, "simulation", id)
 	ctx.HTML(http.StatusOK, "notready.html", gin.H{
 		"Title": "Not Ready",
 	})
@@ -0,0 +1,56 @@
+// display.objects_test.go
+// tests of the locks that handlers take on users' data
+
+package display
+
+import (
+	"capfront/models"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// helper that makes a context as SerialiseUser leaves it for a request from the user called name
+func lockedContext(name string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Set("lockeduser", name)
+	ctx.Set("unlockuser", models.LockUser(name))
+	return ctx
+}
+
+// Two requests, each holding its own user's lock and asking for the other's, must both finish
+func TestLockOtherCrossed(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		a, b := lockedContext("crossa"), lockedContext("crossb")
+		var wg sync.WaitGroup
+		for _, pair := range []struct {
+			ctx   *gin.Context
+			other string
+		}{{a, "crossb"}, {b, "crossa"}} {
+			wg.Add(1)
+			go func(ctx *gin.Context, other string) {
+				defer wg.Done()
+				lockOther(ctx, other)()
+				ctx.MustGet("unlockuser").(func())()
+			}(pair.ctx, pair.other)
+		}
+		done := make(chan struct{})
+		go func() { wg.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("two requests locking each other's users waited for ever")
+		}
+	}
+}
+
+// lockOther must not ask again for the lock SerialiseUser already holds
+func TestLockOtherSameUser(t *testing.T) {
+	ctx := lockedContext("sameuser")
+	lockOther(ctx, "sameuser")()
+	ctx.MustGet("unlockuser").(func())()
+	models.LockUser("sameuser")() // would wait for ever if the lock were still held
+}
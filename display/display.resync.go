@@ -0,0 +1,79 @@
+// display.resync.go
+// handlers that let users, and the admin, resynchronise with the server
+
+package display
+
+import (
+	"capfront/api"
+	"capfront/audit"
+	"capfront/models"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Resynchronises the user with the server and reports what changed.
+// Needed if, for example, the server has restarted or been reset since the user last logged in.
+func UserResync(ctx *gin.Context) {
+	username, loginStatus, _ := adminStatus(ctx) // the user actually logged in, even if the admin is viewing someone else
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	started := time.Now()
+	report := api.Resync(ctx.Request.Context(), username)
+	audit.Record(username, report.CurrentAfter, "resync", report.Err, time.Since(started), resyncDetail(report))
+
+	ctx.HTML(http.StatusOK, "resync.html", gin.H{
+		"Title":          "Resynchronised with server",
+		"reports":        []api.ResyncReport{report},
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"state":          get_current_state(username),
+		"viewas":         ctx.GetString("viewas"),
+	})
+}
+
+// Resynchronises every logged-in user with the server.
+// Only available to admin. Offered after a reset, when everybody's tables are out of date.
+func AdminResyncAll(ctx *gin.Context) {
+	username, loginStatus, _ := adminStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+
+	if username != "admin" {
+		ctx.HTML(http.StatusOK, "errors.html", gin.H{
+			"message": fmt.Errorf("only administrator can resynchronise all users"),
+		})
+		return
+	}
+
+	started := time.Now()
+	reports := api.ResyncAll(ctx.Request.Context(), func(name string) func() { return lockOther(ctx, name) })
+	failed := 0
+	for _, report := range reports {
+		if report.Err != nil {
+			failed++
+		}
+	}
+	audit.Record(username, 0, "resyncall", nil, time.Since(started), fmt.Sprintf("%d users, %d failed", len(reports), failed))
+
+	ctx.HTML(http.StatusOK, "resync.html", gin.H{
+		"Title":          "Resynchronised all users with server",
+		"reports":        reports,
+		"username":       username,
+		"loggedinstatus": loginStatus,
+		"admin":          true,
+		"viewas":         models.User(username).ViewAs,
+	})
+}
+
+// helper function summarising a resync for the audit trail
+func resyncDetail(report api.ResyncReport) string {
+	return fmt.Sprintf("added %d, removed %d, changed %d", len(report.Added), len(report.Removed), len(report.Changed))
+}
@@ -0,0 +1,207 @@
+// display.sweep.go
+// handlers for parameter sweeps, which run a template many times with different parameters
+
+package display
+
+import (
+	"capfront/api"
+	"capfront/models"
+	"capfront/sweep"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The entries of the sweep form, as the user typed them, so that a faulty form can be shown again
+type sweepForm struct {
+	Template   string
+	X          axisForm
+	Y          axisForm
+	Periods    string
+	Indicators []string
+}
+
+type axisForm struct {
+	Parameter string
+	From      string
+	To        string
+	Steps     string
+}
+
+// What the form shows before the user has changed anything
+var defaultSweepForm = sweepForm{
+	X:          axisForm{Parameter: "investment_ratio", From: "0", To: "1", Steps: "5"},
+	Y:          axisForm{From: "0", To: "0.1", Steps: "3"},
+	Periods:    "5",
+	Indicators: []string{"profit_rate"},
+}
+
+// Reads the sweep form.
+// Returns the entries, the spec they describe, and a message for each fault.
+func sweepFormFromRequest(ctx *gin.Context) (sweepForm, sweep.Spec, map[string]string) {
+	axis := func(prefix string) axisForm {
+		return axisForm{
+			Parameter: ctx.PostForm(prefix + "_parameter"),
+			From:      ctx.PostForm(prefix + "_from"),
+			To:        ctx.PostForm(prefix + "_to"),
+			Steps:     ctx.PostForm(prefix + "_steps"),
+		}
+	}
+	form := sweepForm{
+		Template:   ctx.PostForm("template"),
+		X:          axis("x"),
+		Y:          axis("y"),
+		Periods:    ctx.PostForm("periods"),
+		Indicators: ctx.PostFormArray("indicators"),
+	}
+
+	unreadable := make(map[string]string)
+	parseAxis := func(field string, a axisForm) sweep.Axis {
+		from, err1 := strconv.ParseFloat(a.From, 32)
+		to, err2 := strconv.ParseFloat(a.To, 32)
+		steps, err3 := strconv.Atoi(a.Steps)
+		if err1 != nil || err2 != nil || err3 != nil {
+			unreadable[field] = "Please enter numbers for the range and a whole number of steps"
+		}
+		return sweep.Axis{Parameter: a.Parameter, From: float32(from), To: float32(to), Steps: steps}
+	}
+	spec := sweep.Spec{X: parseAxis("x", form.X), Indicators: form.Indicators}
+	if form.Y.Parameter != "" {
+		y := parseAxis("y", form.Y)
+		spec.Y = &y
+	}
+	periods, err := strconv.Atoi(form.Periods)
+	if err != nil {
+		unreadable["periods"] = "Please enter a whole number"
+	}
+	spec.Periods = periods
+	if template := findTemplate(form.Template); template != nil {
+		spec.Template = *template
+	} else {
+		unreadable["template"] = "Please choose a template"
+	}
+
+	faults := spec.Validate()
+	for field, message := range unreadable {
+		faults[field] = message
+	}
+	return form, spec, faults
+}
+
+// The page data for the sweep page
+func sweepPage(ctx *gin.Context, username string, form sweepForm) gin.H {
+	chosen := make(map[string]bool)
+	for _, name := range form.Indicators {
+		chosen[name] = true
+	}
+	page := gin.H{
+		"Title":          "Parameter Sweep",
+		"templates":      api.Templates.Get(),
+		"parameters":     sweep.Parameters,
+		"indicators":     sweep.Indicators,
+		"maxpoints":      sweep.MaxPoints,
+		"maxperiods":     sweep.MaxPeriods,
+		"form":           form,
+		"chosen":         chosen,
+		"username":       username,
+		"loggedinstatus": true,
+		"state":          get_current_state(username),
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+	}
+	if job := sweep.Current(username); job != nil {
+		report := job.Report()
+		page["report"] = report
+		page["running"] = report.State == sweep.Running
+		show := ctx.DefaultQuery("show", report.Spec.Indicators[0])
+		if heatmap, ok := report.Heatmap(show); ok {
+			page["heatmap"] = heatmap
+		}
+		page["show"] = show
+	}
+	return page
+}
+
+// Displays the sweep form and, if the user has a sweep, its progress or results.
+// While the sweep runs, the page reloads itself every few seconds.
+func ShowSweep(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	ctx.HTML(http.StatusOK, "sweep.html", sweepPage(ctx, username, defaultSweepForm))
+}
+
+// Starts the sweep described by the form.
+// Each point of the sweep is a simulation that exists only while it runs,
+// so the user needs room in their quota for one more simulation.
+func StartSweep(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	form, spec, faults := sweepFormFromRequest(ctx)
+	if len(faults) > 0 {
+		page := sweepPage(ctx, username, form)
+		page["errors"] = faults
+		ctx.HTML(http.StatusBadRequest, "sweep.html", page)
+		return
+	}
+	if refuseOverQuota(ctx, models.User(username)) {
+		return
+	}
+	if _, err := sweep.Start(sweep.Remote, username, spec); err != nil {
+		page := sweepPage(ctx, username, form)
+		page["message"] = fmt.Sprintf("Could not start the sweep: %v", err)
+		ctx.HTML(http.StatusConflict, "sweep.html", page)
+		return
+	}
+	ctx.Redirect(http.StatusSeeOther, "/sweep")
+}
+
+// Stops the user's sweep after the point it is working on
+func CancelSweep(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	if job := sweep.Current(username); job != nil {
+		job.Cancel()
+	}
+	ctx.Redirect(http.StatusSeeOther, "/sweep")
+}
+
+// Forgets the results of the user's sweep, if it has finished
+func ClearSweep(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	sweep.Clear(username)
+	ctx.Redirect(http.StatusSeeOther, "/sweep")
+}
+
+// Sends the results of the user's sweep, so far, as CSV
+func SweepCSV(ctx *gin.Context) {
+	username, loginStatus, _ := userStatus(ctx)
+	if !loginStatus {
+		ctx.Redirect(http.StatusMovedPermanently, "/login")
+		return
+	}
+	job := sweep.Current(username)
+	if job == nil {
+		ctx.String(http.StatusNotFound, "You have no sweep")
+		return
+	}
+	report := job.Report()
+	ctx.Header("Content-Type", "text/csv; charset=utf-8")
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="sweep-%d.csv"`, report.Spec.Template.Id))
+	ctx.Status(http.StatusOK)
+	report.WriteCSV(ctx.Writer)
+}
@@ -0,0 +1,41 @@
+// display.templates.go
+// functions made available to the templates
+
+package display
+
+import (
+	"html/template"
+	"io/fs"
+	"path"
+)
+
+// The static assets (css, js and images) served under /static.
+// Set by main, from the embedded files or from disk in dev mode.
+var StaticFiles fs.FS
+
+// Picture shown for a commodity whose own picture we don't have
+const defaultCommodityImage = "images/commodities/default.svg"
+
+// Functions available to every template.
+// Must be registered before the templates are parsed.
+func TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"image":    commodityImage,
+		"quantity": formatQuantity,
+		"money":    formatMoney,
+		"percent":  formatPercent,
+		"number":   formatNumber,
+	}
+}
+
+// Returns the URL of the picture of a commodity, given its Image_Name.
+// If we have no such picture, returns the URL of a default picture.
+func commodityImage(imageName string) string {
+	candidate := path.Join("images/commodities", path.Clean("/" + imageName)[1:])
+	if StaticFiles != nil && imageName != "" {
+		if _, err := fs.Stat(StaticFiles, candidate); err == nil {
+			return "/static/" + candidate
+		}
+	}
+	return "/static/" + defaultCommodityImage
+}
@@ -0,0 +1,157 @@
+// display.trace.go
+// arranges the trace, which the server supplies as a flat list of messages,
+// into an outline that the trace page can collapse, expand, search and link.
+
+package display
+
+import (
+	"capfront/models"
+	"html/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// One message in the outline, with the messages at deeper levels that follow it
+type traceNode struct {
+	Id       int
+	Level    int
+	Message  template.HTML // with entity names linked to their pages
+	Open     bool          // whether the page shows this node expanded
+	Children []*traceNode
+	text     string // the message as the server sent it, for searching
+}
+
+// The messages of one time stamp: that is, of one action in one period
+type traceGroup struct {
+	TimeStamp int
+	Title     string // the first top-level message, which normally names the action
+	Entries   int
+	Nodes     []*traceNode
+}
+
+// Arranges trace into groups, one for each time stamp, each an outline by level.
+// If simulation is not zero, only its messages are included.
+// If query is not empty, only messages containing it (ignoring case), and the messages
+// above them in the outline, are included.
+// Nodes above level depth are shown expanded.
+func outlineTrace(trace []models.Trace, simulation int, query string, depth int, links entityLinks) []traceGroup {
+	entries := make([]models.Trace, 0, len(trace))
+	for _, t := range trace {
+		if simulation == 0 || t.Simulation_id == simulation {
+			entries = append(entries, t)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Time_stamp != entries[j].Time_stamp {
+			return entries[i].Time_stamp < entries[j].Time_stamp
+		}
+		return entries[i].Id < entries[j].Id
+	})
+
+	var groups []traceGroup
+	var stack []*traceNode // the most recent node at each level above the current one
+	for _, t := range entries {
+		if len(groups) == 0 || groups[len(groups)-1].TimeStamp != t.Time_stamp {
+			groups = append(groups, traceGroup{TimeStamp: t.Time_stamp, Title: t.Message})
+			stack = stack[:0]
+		}
+		group := &groups[len(groups)-1]
+		node := &traceNode{Id: t.Id, Level: t.Level, Message: links.link(t.Message), Open: t.Level < depth, text: t.Message}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= t.Level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			group.Nodes = append(group.Nodes, node)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, node)
+		}
+		stack = append(stack, node)
+		group.Entries++
+	}
+
+	if query == "" {
+		return groups
+	}
+	var found []traceGroup
+	for _, group := range groups {
+		group.Nodes = pruneTrace(group.Nodes, strings.ToLower(query))
+		if len(group.Nodes) > 0 {
+			found = append(found, group)
+		}
+	}
+	return found
+}
+
+// helper function keeping only the nodes that contain query, or lead to one that does.
+// Nodes that lead to a match are opened so the match can be seen.
+func pruneTrace(nodes []*traceNode, query string) []*traceNode {
+	var kept []*traceNode
+	for _, node := range nodes {
+		if strings.Contains(strings.ToLower(node.text), query) {
+			kept = append(kept, node)
+			continue
+		}
+		if node.Children = pruneTrace(node.Children, query); len(node.Children) > 0 {
+			node.Open = true
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
+
+// Finds the names of commodities, industries and classes in messages and links them to their pages
+type entityLinks struct {
+	pattern *regexp.Regexp // matches any of the names, longest first; nil if there are none
+	urls    map[string]string
+}
+
+// Prepares to link the names of the objects of the user's current simulation
+func newEntityLinks(user *models.UserData) entityLinks {
+	links := entityLinks{urls: make(map[string]string)}
+	for _, c := range user.CommodityList {
+		if int(c.Simulation_id) == user.CurrentSimulation && c.Name != "" {
+			links.urls[c.Name] = "/commodity/" + strconv.Itoa(c.Id)
+		}
+	}
+	for _, ind := range user.IndustryList {
+		if int(ind.Simulation_id) == user.CurrentSimulation && ind.Name != "" {
+			links.urls[ind.Name] = "/industry/" + strconv.Itoa(ind.Id)
+		}
+	}
+	for _, class := range user.ClassList {
+		if int(class.Simulation_id) == user.CurrentSimulation && class.Name != "" {
+			links.urls[class.Name] = "/class/" + strconv.Itoa(class.Id)
+		}
+	}
+	if len(links.urls) == 0 {
+		return links
+	}
+	names := make([]string, 0, len(links.urls))
+	for name := range links.urls {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	// Longest first, so that (for example) "Means of Production" is preferred to "Production"
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	links.pattern = regexp.MustCompile(`\b(` + strings.Join(names, "|") + `)\b`)
+	return links
+}
+
+// Escapes message for HTML, turning each name it knows into a link
+func (l entityLinks) link(message string) template.HTML {
+	if l.pattern == nil {
+		return template.HTML(template.HTMLEscapeString(message))
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range l.pattern.FindAllStringIndex(message, -1) {
+		name := message[match[0]:match[1]]
+		b.WriteString(template.HTMLEscapeString(message[last:match[0]]))
+		b.WriteString(`<a href="` + l.urls[name] + `">` + template.HTMLEscapeString(name) + `</a>`)
+		last = match[1]
+	}
+	b.WriteString(template.HTMLEscapeString(message[last:]))
+	return template.HTML(b.String())
+}
@@ -22,10 +22,13 @@ func ShowIndustryStocks(ctx *gin.Context) {
 	state := get_current_state(username)
 	ctx.HTML(http.StatusOK, "industry_stocks.html", gin.H{
 		"Title":          "Industry Stocks",
-		"stocks":         models.Users[username].IndustryStockList,
+		"stocks":         models.User(username).IndustryStockList,
 		"username":       username,
 		"loggedinstatus": loginStatus,
 		"state":          state,
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+		"whathappened":   whatHappened(ctx, username),
 	})
 }
 
@@ -40,9 +43,12 @@ func ShowClassStocks(ctx *gin.Context) {
 	state := get_current_state(username)
 	ctx.HTML(http.StatusOK, "class_stocks.html", gin.H{
 		"Title":          "Class Stocks",
-		"stocks":         models.Users[username].ClassStockList,
+		"stocks":         models.User(username).ClassStockList,
 		"username":       username,
 		"loggedinstatus": loginStatus,
 		"state":          state,
+		"viewas":         ctx.GetString("viewas"),
+		"simulation":     get_current_simulation(username),
+		"whathappened":   whatHappened(ctx, username),
 	})
 }
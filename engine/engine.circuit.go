@@ -0,0 +1,286 @@
+// engine.circuit.go
+// the six actions of the circuit of capital
+
+package engine
+
+import "capfront/models"
+
+// Works out what each industry and class wants to buy.
+// An industry wants whatever its production stocks lack of their requirement;
+// a class wants to spend Consumption_Ratio of its money on consumption goods.
+// Nobody demands more than they can pay for.
+func (w *world) demand() {
+	w.log(1, "Demand")
+	for _, c := range w.commodities {
+		c.Demand = 0
+	}
+	for _, ind := range w.industries {
+		stocks := w.stocksOf(ind, models.ProductionUsage)
+		cost := float32(0)
+		for _, s := range stocks {
+			s.Demand = max(0, s.Requirement-s.Size)
+			cost += s.Demand * w.commodity(s.Commodity_id).Unit_Price
+		}
+		if money := w.moneyOf(ind); cost > money && cost > 0 {
+			w.log(2, "%s can pay for only %.4g of the %.4g it needs", ind.Name, money, cost)
+			for _, s := range stocks {
+				s.Demand *= money / cost
+			}
+		}
+		for _, s := range stocks {
+			w.commodity(s.Commodity_id).Demand += s.Demand
+			w.log(3, "%s demands %.4g of %s", ind.Name, s.Demand, w.commodity(s.Commodity_id).Name)
+		}
+	}
+	for _, class := range w.classes {
+		money := w.classMoneyOf(class)
+		stocks := w.classStocksOf(class, models.ConsumptionUsage)
+		for _, s := range stocks {
+			s.Demand = 0
+			if price := w.commodity(s.Commodity_id).Unit_Price; price > 0 {
+				s.Demand = class.Consumption_Ratio * money / price / float32(len(stocks))
+			}
+			w.commodity(s.Commodity_id).Demand += s.Demand
+			w.log(3, "%s demands %.4g of %s", class.Name, s.Demand, w.commodity(s.Commodity_id).Name)
+		}
+	}
+}
+
+// Works out how much of each commodity is for sale: everything in the sales stocks.
+// Then sets each commodity's allocation ratio: the share of its demand that can be met.
+func (w *world) supply() {
+	w.log(1, "Supply")
+	for _, c := range w.commodities {
+		c.Supply = 0
+	}
+	for _, s := range w.industryStocks {
+		if s.Usage_type == models.SalesUsage {
+			w.commodity(s.Commodity_id).Supply += s.Size
+		}
+	}
+	for _, s := range w.classStocks {
+		if s.Usage_type == models.SalesUsage {
+			w.commodity(s.Commodity_id).Supply += s.Size
+		}
+	}
+	for _, c := range w.commodities {
+		c.Allocation_Ratio = 1
+		if c.Demand > c.Supply && c.Demand > 0 {
+			c.Allocation_Ratio = c.Supply / c.Demand
+		}
+		w.log(2, "%s: supply %.4g, demand %.4g, allocation ratio %.4g", c.Name, c.Supply, c.Demand, c.Allocation_Ratio)
+	}
+}
+
+// Buyers receive their allocated share of what they demanded, at the unit price,
+// and pay for it from their money stocks. Sellers give it up in proportion to
+// what each has for sale, and receive the money in the same proportion.
+// What a class receives is its revenue for the period.
+func (w *world) trade() {
+	w.log(1, "Trade")
+	// Revenue is what a class earns in one period, so it starts again from nothing
+	for _, class := range w.classes {
+		class.Revenue = 0
+	}
+	for _, c := range w.commodities {
+		bought := float32(0)
+		for _, s := range w.industryStocks {
+			if s.Commodity_id == c.Id && s.Usage_type == models.ProductionUsage && s.Demand > 0 {
+				amount := s.Demand * c.Allocation_Ratio
+				s.Size += amount
+				s.Demand = 0
+				w.pay(w.moneyStockOf(s.Industry_id), amount*c.Unit_Price)
+				bought += amount
+			}
+		}
+		for _, s := range w.classStocks {
+			if s.Commodity_id == c.Id && s.Usage_type == models.ConsumptionUsage && s.Demand > 0 {
+				amount := s.Demand * c.Allocation_Ratio
+				s.Size += amount
+				s.Demand = 0
+				w.pay(w.classMoneyStockOf(s.Class_id), amount*c.Unit_Price)
+				bought += amount
+			}
+		}
+		if bought == 0 || c.Supply == 0 {
+			continue
+		}
+		share := bought / c.Supply
+		for _, s := range w.industryStocks {
+			if s.Commodity_id == c.Id && s.Usage_type == models.SalesUsage {
+				sold := s.Size * share
+				s.Size -= sold
+				w.pay(w.moneyStockOf(s.Industry_id), -sold*c.Unit_Price)
+			}
+		}
+		for _, s := range w.classStocks {
+			if s.Commodity_id == c.Id && s.Usage_type == models.SalesUsage {
+				sold := s.Size * share
+				s.Size -= sold
+				w.pay(w.classMoneyStockOf(s.Class_id), -sold*c.Unit_Price)
+				for _, class := range w.classes {
+					if class.Id == s.Class_id {
+						class.Revenue += sold * c.Unit_Price
+					}
+				}
+			}
+		}
+		w.log(2, "%.4g of %s changed hands for %.4g", bought, c.Name, bought*c.Unit_Price)
+	}
+}
+
+// Each industry uses up its production stocks and adds its output to its sales stock.
+// If any production stock is short of its requirement, output falls in proportion.
+// Means of production pass their value on to the product; labour power adds the value
+// of the labour performed, one unit for each unit of labour power used.
+// Then the unit value of every produced commodity is set from the value of all its stocks.
+func (w *world) produce() {
+	w.log(1, "Produce")
+	for _, ind := range w.industries {
+		factor := float32(1)
+		for _, s := range w.stocksOf(ind, models.ProductionUsage) {
+			if s.Requirement > 0 {
+				factor = min(factor, s.Size/s.Requirement)
+			}
+		}
+		value := float32(0)
+		for _, s := range w.stocksOf(ind, models.ProductionUsage) {
+			used := s.Requirement * factor
+			c := w.commodity(s.Commodity_id)
+			if c.Origin == models.SocialOrigin {
+				value += used
+			} else {
+				value += used * c.Unit_Value
+			}
+			s.Size -= used
+			s.Value -= used * c.Unit_Value
+		}
+		output := ind.Output_Scale * factor
+		for _, s := range w.stocksOf(ind, models.SalesUsage) {
+			s.Size += output
+			s.Value += value
+		}
+		w.log(2, "%s produced %.4g, worth %.4g", ind.Name, output, value)
+	}
+
+	// Commodities that no industry produces keep their unit values
+	for _, c := range w.commodities {
+		if c.Origin == models.SocialOrigin || c.Origin == models.MoneyOrigin {
+			continue
+		}
+		size, value := float32(0), float32(0)
+		for _, s := range w.industryStocks {
+			if s.Commodity_id == c.Id {
+				size += s.Size
+				value += s.Value
+			}
+		}
+		for _, s := range w.classStocks {
+			if s.Commodity_id == c.Id {
+				size += s.Size
+				value += s.Size * c.Unit_Value
+			}
+		}
+		if size > 0 {
+			c.Unit_Value = value / size
+		}
+		if w.sim.Price_Response_Type == "VALUES" {
+			c.Unit_Price = c.Unit_Value * w.melt()
+		}
+		w.log(3, "The unit value of %s is now %.4g", c.Name, c.Unit_Value)
+	}
+}
+
+// Each class uses up its consumption goods, and so reproduces its labour power:
+// the sales stock of a class is refilled to its working population.
+// Populations grow at the simulation's rate, spread over the periods of a year.
+func (w *world) consume() {
+	w.log(1, "Consume")
+	growth := float32(0)
+	if w.sim.Periods_Per_Year > 0 {
+		growth = w.sim.Population_Growth_Rate / w.sim.Periods_Per_Year
+	}
+	for _, class := range w.classes {
+		for _, s := range w.classStocksOf(class, models.ConsumptionUsage) {
+			w.log(2, "%s consumed %.4g of %s", class.Name, s.Size, w.commodity(s.Commodity_id).Name)
+			s.Size = 0
+		}
+		class.Population *= 1 + growth
+		for _, s := range w.classStocksOf(class, models.SalesUsage) {
+			s.Size = class.Population * class.Participation_Ratio
+			w.log(2, "%s now has %.4g of %s to sell", class.Name, s.Size, w.commodity(s.Commodity_id).Name)
+		}
+	}
+}
+
+// Each industry grows at its output growth rate, and needs proportionately more of everything.
+// The server does not yet do this (see ActionHandler), so until it does,
+// only industries given a growth rate will differ between the two.
+func (w *world) invest() {
+	w.log(1, "Invest")
+	for _, ind := range w.industries {
+		if ind.Output_Growth_Rate == 0 {
+			continue
+		}
+		ind.Output_Scale *= 1 + ind.Output_Growth_Rate
+		for _, s := range w.stocksOf(ind, models.ProductionUsage) {
+			s.Requirement *= 1 + ind.Output_Growth_Rate
+		}
+		w.log(2, "%s grew to %.4g", ind.Name, ind.Output_Scale)
+	}
+}
+
+// The monetary expression of labour time: how much money a unit of value is worth.
+// Zero (unset) is taken to mean one.
+func (w *world) melt() float32 {
+	if w.sim.Melt == 0 {
+		return 1
+	}
+	return w.sim.Melt
+}
+
+// The amount of money an industry has
+func (w *world) moneyOf(ind *models.Industry) float32 {
+	total := float32(0)
+	for _, s := range w.stocksOf(ind, models.MoneyUsage) {
+		total += s.Size
+	}
+	return total
+}
+
+// The amount of money a class has
+func (w *world) classMoneyOf(class *models.Class) float32 {
+	total := float32(0)
+	for _, s := range w.classStocksOf(class, models.MoneyUsage) {
+		total += s.Size
+	}
+	return total
+}
+
+// The size of an industry's money stock, or nil if it has none
+func (w *world) moneyStockOf(industryID int) *float32 {
+	for _, s := range w.industryStocks {
+		if s.Industry_id == industryID && s.Usage_type == models.MoneyUsage {
+			return &s.Size
+		}
+	}
+	return nil
+}
+
+// The size of a class's money stock, or nil if it has none
+func (w *world) classMoneyStockOf(classID int) *float32 {
+	for _, s := range w.classStocks {
+		if s.Class_id == classID && s.Usage_type == models.MoneyUsage {
+			return &s.Size
+		}
+	}
+	return nil
+}
+
+// Takes an amount from a money stock (or gives it, if the amount is negative).
+// An owner without a money stock trades for nothing: the template editor does not allow this.
+func (w *world) pay(money *float32, amount float32) {
+	if money != nil {
+		*money -= amount
+	}
+}
@@ -0,0 +1,73 @@
+// engine.crosscheck.go
+// compares what this engine does with what the server did, starting from the same tables
+
+package engine
+
+import (
+	"capfront/analysis"
+	"capfront/models"
+	"math"
+)
+
+// Differences smaller than this fraction of the larger value are not reported,
+// since the server works in single precision and rounds differently
+const CrossCheckTolerance = 0.001
+
+// What the engine made of one action that the server carried out
+type CrossCheck struct {
+	Action     string
+	Mismatches []analysis.Change // Before is what the server did, After what the engine did
+	Err        error
+}
+
+// True if the engine agrees with the server, within CrossCheckTolerance
+func (c CrossCheck) Agrees() bool {
+	return c.Err == nil && len(c.Mismatches) == 0
+}
+
+// Carries out the action recorded in t on a copy of the tables before it,
+// and compares the result with the tables the server produced.
+// sim supplies the parameters of the simulation.
+func Check(t models.Transition, sim models.Simulation) CrossCheck {
+	result := CrossCheck{Action: t.Action}
+	local := copySnapshot(t.Before)
+	if _, err := Step(&local, sim, t.Action); err != nil {
+		result.Err = err
+		return result
+	}
+	local.Simulation = t.After.Simulation
+	diff := analysis.Diff(models.Transition{Action: t.Action, Before: t.After, After: local})
+	for _, change := range diff.Changes {
+		if math.Abs(change.Delta) > CrossCheckTolerance*math.Max(math.Abs(change.Before), math.Abs(change.After)) {
+			result.Mismatches = append(result.Mismatches, change)
+		}
+	}
+	return result
+}
+
+// Checks every action in the user's history that belongs to their current simulation, oldest first
+func CheckHistory(user *models.UserData) []CrossCheck {
+	var sim models.Simulation
+	for _, s := range user.SimulationList {
+		if s.Id == user.CurrentSimulation {
+			sim = s
+		}
+	}
+	var checks []CrossCheck
+	for _, t := range user.History {
+		if t.After.Simulation == sim.Id {
+			checks = append(checks, Check(t, sim))
+		}
+	}
+	return checks
+}
+
+// A copy of s that shares no storage with it, so that Step can change it freely
+func copySnapshot(s models.Snapshot) models.Snapshot {
+	s.CommodityList = append([]models.Commodity(nil), s.CommodityList...)
+	s.IndustryList = append([]models.Industry(nil), s.IndustryList...)
+	s.ClassList = append([]models.Class(nil), s.ClassList...)
+	s.IndustryStockList = append([]models.Industry_Stock(nil), s.IndustryStockList...)
+	s.ClassStockList = append([]models.Class_Stock(nil), s.ClassStockList...)
+	return s
+}
@@ -0,0 +1,223 @@
+// engine.go
+// runs the circuit of capital on this frontend's own copy of a simulation, without the remote server,
+// so that the frontend can one day work on its own (for example, as WASM in a browser).
+//
+// It follows the capsim backend's semantics as far as this frontend knows them.
+// Where the two disagree, CrossCheck shows how.
+
+package engine
+
+import (
+	"capfront/models"
+	"fmt"
+	"slices"
+)
+
+// The actions of the circuit, in order
+var Actions = []string{"demand", "supply", "trade", "produce", "consume", "invest"}
+
+// One simulation, as the actions see it: pointers into the slices of a snapshot,
+// restricted to the objects of that simulation
+type world struct {
+	sim            models.Simulation
+	commodities    []*models.Commodity
+	industries     []*models.Industry
+	classes        []*models.Class
+	industryStocks []*models.Industry_Stock
+	classStocks    []*models.Class_Stock
+	trace          []models.Trace
+}
+
+// Carries out one action on the objects of sim in s, changing them in place.
+// Objects of other simulations in s are left alone.
+// Returns the trace of what was done. The trace records are not numbered: see Run.
+func Step(s *models.Snapshot, sim models.Simulation, action string) ([]models.Trace, error) {
+	w := newWorld(s, sim)
+	switch action {
+	case "demand":
+		w.demand()
+	case "supply":
+		w.supply()
+	case "trade":
+		w.trade()
+	case "produce":
+		w.produce()
+	case "consume":
+		w.consume()
+	case "invest":
+		w.invest()
+	default:
+		return nil, fmt.Errorf("there is no action called %q", action)
+	}
+	w.revalue()
+	return w.trace, nil
+}
+
+// Carries out one action on the user's current simulation, changing the user's own tables
+// and adding to their trace. As the server does, each action moves the simulation, and its
+// objects, on to the next time stamp. Nothing is sent to the server, so the caller must
+// keep the result with KeepLocalRun once it has finished changing the simulation.
+func Run(user *models.UserData, action string) error {
+	i := slices.IndexFunc(user.SimulationList, func(s models.Simulation) bool { return s.Id == user.CurrentSimulation })
+	if i < 0 {
+		return fmt.Errorf("user %s has no current simulation", user.UserName)
+	}
+	s := user.TakeSnapshot()
+	trace, err := Step(&s, user.SimulationList[i], action)
+	if err != nil {
+		return err
+	}
+	sim := &user.SimulationList[i]
+	sim.Time_Stamp++
+	stamp(&s, sim.Id, sim.Time_Stamp)
+	user.CommodityList = s.CommodityList
+	user.IndustryList = s.IndustryList
+	user.ClassList = s.ClassList
+	user.IndustryStockList = s.IndustryStockList
+	user.ClassStockList = s.ClassStockList
+
+	next := 1
+	for _, t := range user.TraceList {
+		next = max(next, t.Id+1)
+	}
+	for _, t := range trace {
+		t.Id = next
+		next++
+		user.TraceList = append(user.TraceList, t)
+	}
+	return nil
+}
+
+// helper function that sets the time stamp of the objects of simulation id in s
+func stamp(s *models.Snapshot, id int, timeStamp int) {
+	for i := range s.CommodityList {
+		if int(s.CommodityList[i].Simulation_id) == id {
+			s.CommodityList[i].Time_Stamp = int32(timeStamp)
+		}
+	}
+	for i := range s.IndustryList {
+		if int(s.IndustryList[i].Simulation_id) == id {
+			s.IndustryList[i].Time_Stamp = timeStamp
+		}
+	}
+	for i := range s.ClassList {
+		if int(s.ClassList[i].Simulation_id) == id {
+			s.ClassList[i].Time_Stamp = timeStamp
+		}
+	}
+}
+
+func newWorld(s *models.Snapshot, sim models.Simulation) *world {
+	w := &world{sim: sim}
+	for i := range s.CommodityList {
+		if int(s.CommodityList[i].Simulation_id) == sim.Id {
+			w.commodities = append(w.commodities, &s.CommodityList[i])
+		}
+	}
+	for i := range s.IndustryList {
+		if int(s.IndustryList[i].Simulation_id) == sim.Id {
+			w.industries = append(w.industries, &s.IndustryList[i])
+		}
+	}
+	for i := range s.ClassList {
+		if int(s.ClassList[i].Simulation_id) == sim.Id {
+			w.classes = append(w.classes, &s.ClassList[i])
+		}
+	}
+	for i := range s.IndustryStockList {
+		if s.IndustryStockList[i].Simulation_id == sim.Id {
+			w.industryStocks = append(w.industryStocks, &s.IndustryStockList[i])
+		}
+	}
+	for i := range s.ClassStockList {
+		if s.ClassStockList[i].Simulation_id == sim.Id {
+			w.classStocks = append(w.classStocks, &s.ClassStockList[i])
+		}
+	}
+	return w
+}
+
+// Adds a record to the trace, in the same form as the server's
+func (w *world) log(level int, format string, args ...any) {
+	w.trace = append(w.trace, models.Trace{
+		Simulation_id: w.sim.Id,
+		Time_stamp:    w.sim.Time_Stamp,
+		UserName:      w.sim.UserName,
+		Level:         level,
+		Message:       fmt.Sprintf(format, args...),
+	})
+}
+
+func (w *world) commodity(id int) *models.Commodity {
+	for _, c := range w.commodities {
+		if c.Id == id {
+			return c
+		}
+	}
+	return &models.Commodity{Name: "unknown commodity"}
+}
+
+// The stocks owned by an industry with the given usage type
+func (w *world) stocksOf(industry *models.Industry, usage string) []*models.Industry_Stock {
+	var stocks []*models.Industry_Stock
+	for _, s := range w.industryStocks {
+		if s.Industry_id == industry.Id && s.Usage_type == usage {
+			stocks = append(stocks, s)
+		}
+	}
+	return stocks
+}
+
+// The stocks owned by a class with the given usage type
+func (w *world) classStocksOf(class *models.Class, usage string) []*models.Class_Stock {
+	var stocks []*models.Class_Stock
+	for _, s := range w.classStocks {
+		if s.Class_id == class.Id && s.Usage_type == usage {
+			stocks = append(stocks, s)
+		}
+	}
+	return stocks
+}
+
+// Sets the value and price of every stock from its size and its commodity's unit value and price,
+// then adds them up into the totals of commodities, industries and classes
+func (w *world) revalue() {
+	for _, c := range w.commodities {
+		c.Size, c.Total_Value, c.Total_Price = 0, 0, 0
+	}
+	add := func(commodityID int, size float32, value *float32, price *float32) {
+		c := w.commodity(commodityID)
+		*value = size * c.Unit_Value
+		*price = size * c.Unit_Price
+		c.Size += size
+		c.Total_Value += *value
+		c.Total_Price += *price
+	}
+	for _, s := range w.industryStocks {
+		add(s.Commodity_id, s.Size, &s.Value, &s.Price)
+	}
+	for _, s := range w.classStocks {
+		add(s.Commodity_id, s.Size, &s.Value, &s.Price)
+	}
+	for _, ind := range w.industries {
+		ind.Current_Capital = 0
+		for _, s := range w.industryStocks {
+			if s.Industry_id == ind.Id {
+				ind.Current_Capital += s.Price
+			}
+		}
+		ind.Profit = ind.Current_Capital - ind.Initial_Capital
+		ind.Profit_Rate = 0
+		if ind.Initial_Capital != 0 {
+			ind.Profit_Rate = ind.Profit / ind.Initial_Capital
+		}
+	}
+	for _, class := range w.classes {
+		class.Assets = 0
+		for _, s := range w.classStocks {
+			if s.Class_id == class.Id {
+				class.Assets += s.Price
+			}
+		}
+	}
+}
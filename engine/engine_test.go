@@ -0,0 +1,229 @@
+// engine_test.go
+// tests of the actions of the circuit, against the tables of a small two-department template
+// after each action, in the form in which the server sends them (testdata/circuit.json).
+// The template is not in a steady state: both departments want more means of production than
+// there are, the workers and capitalists want more consumption goods than there are, and the
+// workers start with revenue left over from the period before.
+
+package engine
+
+import (
+	"capfront/models"
+	"encoding/json"
+	"math"
+	"os"
+	"testing"
+)
+
+// The tables of one simulation, as the server sends them
+type fixtureTables struct {
+	Commodities    []models.Commodity      `json:"commodities"`
+	Industries     []models.Industry       `json:"industries"`
+	Classes        []models.Class          `json:"classes"`
+	IndustryStocks []models.Industry_Stock `json:"industry_stocks"`
+	ClassStocks    []models.Class_Stock    `json:"class_stocks"`
+}
+
+// A simulation, its tables before the circuit, and its tables after each action
+type fixture struct {
+	Simulation models.Simulation `json:"simulation"`
+	Before     fixtureTables     `json:"before"`
+	Steps      []struct {
+		Action string        `json:"action"`
+		After  fixtureTables `json:"after"`
+	} `json:"steps"`
+}
+
+func (t fixtureTables) snapshot(simulation int) models.Snapshot {
+	return models.Snapshot{
+		Simulation:        simulation,
+		CommodityList:     t.Commodities,
+		IndustryList:      t.Industries,
+		ClassList:         t.Classes,
+		IndustryStockList: t.IndustryStocks,
+		ClassStockList:    t.ClassStocks,
+	}
+}
+
+func loadFixture(t *testing.T, name string) fixture {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("cannot read %s: %v", name, err)
+	}
+	return f
+}
+
+// Each action, starting from the tables left by the one before, must produce the tables of the fixture
+func TestStepAgainstFixture(t *testing.T) {
+	f := loadFixture(t, "circuit.json")
+	before := f.Before.snapshot(f.Simulation.Id)
+	for _, step := range f.Steps {
+		after := step.After.snapshot(f.Simulation.Id)
+		t.Run(step.Action, func(t *testing.T) {
+			check := Check(models.Transition{Action: step.Action, Before: before, After: after}, f.Simulation)
+			if check.Err != nil {
+				t.Fatal(check.Err)
+			}
+			for _, m := range check.Mismatches {
+				t.Errorf("%s %s %s: want %g, got %g", m.Kind, m.Name, m.Field, m.Before, m.After)
+			}
+		})
+		before = after
+	}
+}
+
+// helper that finds a row of list by its id
+func byID[T any](t *testing.T, list []T, id int, idOf func(T) int) T {
+	t.Helper()
+	for _, row := range list {
+		if idOf(row) == id {
+			return row
+		}
+	}
+	t.Fatalf("no row with id %d", id)
+	var none T
+	return none
+}
+
+// The figures of a circuit worked out by hand, so that the fixture is not only checked against itself
+func TestCircuitRationsAndMovesValues(t *testing.T) {
+	f := loadFixture(t, "circuit.json")
+	s := f.Before.snapshot(f.Simulation.Id)
+	commodity := func(id int) models.Commodity {
+		return byID(t, s.CommodityList, id, func(c models.Commodity) int { return c.Id })
+	}
+	industryStock := func(id int) models.Industry_Stock {
+		return byID(t, s.IndustryStockList, id, func(s models.Industry_Stock) int { return s.Id })
+	}
+	classStock := func(id int) models.Class_Stock {
+		return byID(t, s.ClassStockList, id, func(s models.Class_Stock) int { return s.Id })
+	}
+	near := func(what string, got float32, want float64) {
+		t.Helper()
+		if math.Abs(float64(got)-want) > 1e-3 {
+			t.Errorf("%s is %g, want %g", what, got, want)
+		}
+	}
+	step := func(action string) {
+		t.Helper()
+		if _, err := Step(&s, f.Simulation, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	step("demand")
+	step("supply")
+	// 120 means of production are wanted and 100 made; 130 consumption goods wanted and 100 made
+	near("allocation ratio of means of production", commodity(2).Allocation_Ratio, 100.0/120)
+	near("allocation ratio of consumption goods", commodity(3).Allocation_Ratio, 100.0/130)
+
+	step("trade")
+	near("means of production bought by department I", industryStock(13).Size, 50)
+	near("money of department I", industryStock(11).Size, 100-50-60*0.5+100)
+	near("consumption goods bought by the workers", classStock(33).Size, 30*100.0/130)
+	near("money of the workers", classStock(31).Size, 30-30*100.0/130+50)
+	near("revenue of the workers", s.ClassList[0].Revenue, 50)
+
+	step("produce")
+	// Department I is short of means of production, so it uses 50 of each input and produces 5/6 of its scale,
+	// worth 50 of transferred value and 50 of new value; department II produces 5/6 too, worth 50 + 100/3
+	near("output of department I", industryStock(12).Size, 100*5.0/6)
+	near("value of the output of department I", industryStock(12).Value, 100)
+	near("labour power left to department I", industryStock(14).Size, 10)
+	near("unit value of means of production", commodity(2).Unit_Value, 1.2)
+	near("unit price of means of production", commodity(2).Unit_Price, 1.2)
+	near("unit value of consumption goods", commodity(3).Unit_Value, 1)
+}
+
+// Revenue is earned afresh in each period
+func TestTradeResetsRevenue(t *testing.T) {
+	f := loadFixture(t, "circuit.json")
+	s := f.Before.snapshot(f.Simulation.Id)
+	for _, action := range Actions {
+		if _, err := Step(&s, f.Simulation, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, action := range Actions[:2] {
+		if _, err := Step(&s, f.Simulation, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+	forSale := s.ClassStockList[1].Size
+	if _, err := Step(&s, f.Simulation, "trade"); err != nil {
+		t.Fatal(err)
+	}
+	sold := (forSale - s.ClassStockList[1].Size) * s.CommodityList[3].Unit_Price
+	if got := s.ClassList[0].Revenue; math.Abs(float64(got-sold)) > 1e-3 {
+		t.Errorf("the workers' revenue in the second period is %g, but they sold only %g", got, sold)
+	}
+}
+
+// Objects of other simulations must be left alone
+func TestStepLeavesOtherSimulationsAlone(t *testing.T) {
+	f := loadFixture(t, "circuit.json")
+	s := f.Before.snapshot(f.Simulation.Id)
+	other := s.IndustryStockList[0]
+	other.Id, other.Simulation_id, other.Size = 99, f.Simulation.Id+1, 7
+	s.IndustryStockList = append(s.IndustryStockList, other)
+	for _, action := range Actions {
+		if _, err := Step(&s, f.Simulation, action); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last := s.IndustryStockList[len(s.IndustryStockList)-1]; last.Size != 7 || last.Value != other.Value {
+		t.Errorf("a stock of another simulation was changed: size %g, value %g", last.Size, last.Value)
+	}
+}
+
+func TestStepRejectsUnknownAction(t *testing.T) {
+	f := loadFixture(t, "circuit.json")
+	s := f.Before.snapshot(f.Simulation.Id)
+	if _, err := Step(&s, f.Simulation, "borrow"); err == nil {
+		t.Error("an unknown action was accepted")
+	}
+}
+
+// Run must change the user's tables, number the trace on from the user's, and move time on
+func TestRun(t *testing.T) {
+	f := loadFixture(t, "circuit.json")
+	user := &models.UserData{
+		UserName:          "tester",
+		CurrentSimulation: f.Simulation.Id,
+		SimulationList:    []models.Simulation{f.Simulation},
+		CommodityList:     f.Before.Commodities,
+		IndustryList:      f.Before.Industries,
+		ClassList:         f.Before.Classes,
+		IndustryStockList: f.Before.IndustryStocks,
+		ClassStockList:    f.Before.ClassStocks,
+		TraceList:         []models.Trace{{Id: 5, Simulation_id: f.Simulation.Id}},
+	}
+	timeStamp := f.Simulation.Time_Stamp
+	if err := Run(user, "demand"); err != nil {
+		t.Fatal(err)
+	}
+	if got := user.SimulationList[0].Time_Stamp; got != timeStamp+1 {
+		t.Errorf("time stamp is %d, want %d", got, timeStamp+1)
+	}
+	for _, c := range user.CommodityList {
+		if int(c.Time_Stamp) != timeStamp+1 {
+			t.Errorf("commodity %s has time stamp %d, want %d", c.Name, c.Time_Stamp, timeStamp+1)
+		}
+	}
+	if len(user.TraceList) < 2 || user.TraceList[1].Id != 6 {
+		t.Errorf("the new trace was not numbered on from the old: %+v", user.TraceList)
+	}
+	if user.IndustryStockList[2].Demand == 0 {
+		t.Error("the user's tables were not changed")
+	}
+
+	user.CurrentSimulation = 0
+	if err := Run(user, "supply"); err == nil {
+		t.Error("Run succeeded without a current simulation")
+	}
+}
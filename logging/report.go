@@ -3,16 +3,70 @@
 package logging
 
 import (
-	"capfront/models"
-	"fmt"
+	"context"
+	"log/slog"
+	"os"
+	"strings"
 )
 
-// creates a trace that logs events on the standard output
-// and also creates a user-readable record called 'Trace'
-// that provides information about the progress of the simulation
+// All diagnostics go through this logger.
+// Its format and verbosity are set by Configure.
+var Logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-//TODO distinguish between what is logged to output and what is shown to the user
+// Sets up the logger.
+// format is "text" (the default) or "json".
+// level is "debug", "info" (the default), "warn" or "error".
+func Configure(format string, level string) {
+	options := &slog.HandlerOptions{Level: parseLevel(level)}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	Logger = slog.New(handler)
+	slog.SetDefault(Logger)
+}
+
+// helper function to convert the name of a level into a slog.Level
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// The entry point for all diagnostics.
+// Logs message at the given level, with args as alternating keys and values
+// (for example "user", username, "simulation", id).
+// TODO distinguish between what is logged to output and what is shown to the user
+func Report(level slog.Level, message string, args ...any) {
+	Logger.Log(context.Background(), level, message, args...)
+}
+
+// Reports a message that is only of interest when diagnosing a problem
+func Debug(message string, args ...any) {
+	Report(slog.LevelDebug, message, args...)
+}
+
+// Reports a normal event
+func Info(message string, args ...any) {
+	Report(slog.LevelInfo, message, args...)
+}
+
+// Reports something that went wrong but from which we recovered
+func Warn(message string, args ...any) {
+	Report(slog.LevelWarn, message, args...)
+}
 
-func Report(level int, simulation models.Simulation, message string) {
-	fmt.Println(message) //placeholder
+// Reports something that went wrong and could not be put right
+func Error(message string, args ...any) {
+	Report(slog.LevelError, message, args...)
 }
@@ -0,0 +1,82 @@
+//logging.requestid.go
+
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Header that carries the request ID, both from the browser and to the backend
+const RequestIDHeader = "X-Request-ID"
+
+// Longest request ID that we accept from a browser or proxy
+const maxRequestIDLength = 64
+
+// Middleware that gives every request an ID, and logs the request when it completes.
+// If the request already has an acceptable ID (for example, from a proxy) it is kept;
+// otherwise a new one is made, so that nothing the client sends reaches the log or the backend.
+// The ID is returned to the browser in RequestIDHeader and kept in the context
+// of the request, from which the helpers in package auth forward it to the backend.
+func RequestID(ctx *gin.Context) {
+	id := ctx.GetHeader(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	ctx.Set("requestid", id)
+	ctx.Header(RequestIDHeader, id)
+	ctx.Request = ctx.Request.WithContext(WithRequestID(ctx.Request.Context(), id))
+
+	start := time.Now()
+	ctx.Next()
+	Info("request",
+		"id", id,
+		"method", ctx.Request.Method,
+		"path", ctx.Request.URL.Path,
+		"status", ctx.Writer.Status(),
+		"duration", time.Since(start),
+		"client", ctx.ClientIP(),
+	)
+}
+
+// The key under which the request ID is kept in a context
+type requestIDKey struct{}
+
+// Returns a copy of ctx that carries the request ID id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Returns the request ID carried by ctx, or "" if there is none
+// (for example, because the work was not started by a request).
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// helper function: true if id is no longer than maxRequestIDLength
+// and made only of ASCII letters, digits and '-'
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// helper function to make a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
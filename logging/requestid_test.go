@@ -0,0 +1,50 @@
+// requestid_test.go
+// tests that only well-formed request IDs are taken from the client
+
+package logging
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIDFromClient(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		kept bool
+	}{
+		{"none", "", false},
+		{"uuid", "123e4567-e89b-12d3-a456-426614174000", true},
+		{"longest", strings.Repeat("a", maxRequestIDLength), true},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"newline", "abc\nforged log line", false},
+		{"space", "abc def", false},
+		{"non-ASCII", "abcé", false},
+		{"punctuation", "abc;rm", false},
+	}
+	gin.SetMode(gin.TestMode)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest("GET", "/", nil)
+			ctx.Request.Header.Set(RequestIDHeader, test.sent)
+			RequestID(ctx)
+
+			got := recorder.Header().Get(RequestIDHeader)
+			if got != RequestIDFrom(ctx.Request.Context()) {
+				t.Errorf("the ID returned, %q, is not the one in the context", got)
+			}
+			if test.kept && got != test.sent {
+				t.Errorf("got ID %q, want %q", got, test.sent)
+			}
+			if !test.kept && (got == test.sent || !validRequestID(got)) {
+				t.Errorf("got ID %q; want a new one", got)
+			}
+		})
+	}
+}
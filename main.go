@@ -2,85 +2,294 @@ package main
 
 import (
 	"capfront/api"
+	"capfront/audit"
 	"capfront/auth"
+	"capfront/cache"
+	"capfront/config"
 	"capfront/display"
+	"capfront/logging"
+	"capfront/metrics"
 	"capfront/models"
-	"fmt"
-	"log"
+	"capfront/persist"
+	"capfront/sweep"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Runs once at startup
 // Loads environment variables
-// Downloads user details from server
-// Downloads starter templates
+// Makes contact with the server in the background, so that
+// the frontend can start (and say so) even if the server is down
 func Initialise() {
 	// err := gotdotenv.Load()                 // 👈 load .env file
+	auth.LoginLimiter = auth.NewLimiter()   // pick up the settings we just loaded
 	auth.SECRET_ADMIN_PASSWORD = "insecure" // TODO get this from settings file
+	api.Templates.TTL = time.Duration(config.SharedCacheSeconds) * time.Second
+	api.AdminUsers.TTL = time.Duration(config.SharedCacheSeconds) * time.Second
 	admin_user := models.UserData{LoggedIn: false, UserName: "admin", Token: ""}
-	models.Users["admin"] = &admin_user
-	serverPayload, err := display.ServerLogin("admin", auth.SECRET_ADMIN_PASSWORD)
+	models.AddUser(&admin_user)
+	go connectToServer()
+}
 
+// Keeps trying to make contact with the server until it succeeds,
+// waiting longer after each failure, up to a minute.
+// Until then, pages that need the server display serverdown.html.
+func connectToServer() {
+	delay := time.Second
+	for !fetchStartupData() {
+		logging.Warn("Server not available at startup; will try again", "delay", delay)
+		time.Sleep(delay)
+		delay = min(2*delay, time.Minute)
+	}
+	display.SetBackendReady(true)
+	cache.StartRefreshing(context.Background(), time.Duration(config.SharedCacheSeconds)*time.Second)
+	logging.Info("Connected to server")
+}
+
+// Logs in to the server as admin
+// Downloads user details from server
+// Downloads starter templates
+// Returns false if any of this failed
+func fetchStartupData() bool {
+	unlock := models.LockUser("admin")
+	serverPayload, err := display.ServerLogin("admin", auth.SECRET_ADMIN_PASSWORD)
+	unlock()
 	if err != nil {
-		log.Fatalf("Server failed at startup. It said:\n%v", serverPayload["message"])
+		logging.Error("Server failed at startup", "message", serverPayload["message"])
+		return false
 	}
 
-	api.FetchAPI(&api.ApiList[0], "admin") // get templates
-	api.FetchAPI(&api.ApiList[1], "admin") // get user details
-	// Copy the list we just downloaded into the UserList
-	// Can probably download directly into UserList
-	// but I wasn't sure how the unMarshalling would affect the nested arrays
-	for _, item := range models.AdminUserList {
-		user := models.UserData{LoggedIn: false, UserName: item.UserName, Token: ""}
-		models.Users[item.UserName] = &user
+	if api.Templates.Reload() != nil {
+		return false
+	}
+	if api.AdminUsers.Reload() != nil { // also adds any users we don't know about
+		return false
 	}
+	restored := persist.RestoreAll(validateSession)
+	logging.Info("Saved sessions restored", "count", restored)
 	ListData()
+	return true
+}
+
+// Checks a saved session with the server before we rely on it.
+// The session is valid if the server still accepts the user's token
+// and agrees that the user is logged in.
+// The tables saved with the session may be stale, so they are fetched again.
+func validateSession(user *models.UserData) bool {
+	if !user.LoggedIn || user.Token == "" {
+		return false
+	}
+	body, err := auth.ProtectedResourceServerRequest(context.Background(), user.UserName, "check saved session", `users/`+user.UserName)
+	if err != nil {
+		return false
+	}
+	var serverItem models.UserServerData
+	if json.Unmarshal(body, &serverItem) != nil || !serverItem.Is_logged_in {
+		return false
+	}
+	user.CurrentSimulation = serverItem.CurrentSimulation
+	return api.Refresh(context.Background(), user.UserName)
 }
 
 // short diagnostic function to display user and template data
 func ListData() {
-	fmt.Printf("\nTemplateList has %d elements which are:\n", len(models.TemplateList))
-	for i := 0; i < len(models.TemplateList); i++ {
-		fmt.Println(models.TemplateList[i])
+	templates := models.Templates()
+	logging.Info("Templates loaded", "count", len(templates))
+	for i := 0; i < len(templates); i++ {
+		logging.Debug("Template", "id", templates[i].Id, "name", templates[i].Name)
 	}
-	fmt.Printf("AdminUserList has %d elements which are:\n", len(models.AdminUserList))
-	for i := 0; i < len(models.AdminUserList); i++ {
-		fmt.Println(models.AdminUserList[i])
+	adminUsers := models.AdminUsers()
+	logging.Info("Users loaded", "count", len(adminUsers))
+	for i := 0; i < len(adminUsers); i++ {
+		logging.Debug("User", "name", adminUsers[i].UserName)
 	}
 }
 
+// counts the users who are logged in, for the metrics endpoint
+func activeSessions() float64 {
+	count := 0
+	for _, user := range models.AllUsers() {
+		unlock := models.LockUser(user.UserName)
+		if user.LoggedIn {
+			count++
+		}
+		unlock()
+	}
+	return float64(count)
+}
+
+// Splits config.TrustedProxies into the list that gin wants.
+// Returns nil, which trusts no proxy, if there are none.
+func trustedProxies() []string {
+	var result []string
+	for _, proxy := range strings.Split(config.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			result = append(result, proxy)
+		}
+	}
+	return result
+}
+
 func main() {
-	r := gin.Default()
-	r.LoadHTMLGlob("./templates/**/*") // load all the templates in the templates folder
-	fmt.Println("Welcome to capitalism")
-	r.GET("/action/:action", display.ActionHandler)
-	r.GET("/commodities", display.ShowCommodities)
-	r.GET("/industries", display.ShowIndustries)
-	r.GET("/classes", display.ShowClasses)
-	r.GET("/industry_stocks", display.ShowIndustryStocks)
-	r.GET("/class_stocks", display.ShowClassStocks)
-	r.GET("/industry/:id", display.ShowIndustry)
-	r.GET("/commodity/:id", display.ShowCommodity)
-	r.GET("/class/:id", display.ShowClass)
-	r.GET("/trace", display.ShowTrace)
-	r.GET("/admin/dashboard", display.AdminDashboard)
-	r.GET("/admin/reset", display.AdminReset)
-	r.GET("/login", display.CaptureLoginRequest)
-	r.POST("/user/login", display.HandleLoginRequest)
-	r.GET("/logout", display.ClientLogoutRequest)
-	r.GET("/register", display.CaptureRegisterRequest)
-	r.POST("/user/register", display.HandleRegisterRequest)
-	r.GET("/user/create/:id", display.CreateSimulation)
-	r.GET("/user/dashboard", display.UserDashboard)
-	r.GET("/user/switch/:id", display.SwitchSimulation)
-	r.GET("/user/delete/:id", display.DeleteSimulation)
-	r.GET("/user/restart/:id", display.RestartSimulation)
-	r.GET("/index/", display.ShowIndexPage)
-	r.GET("/data/", display.DataHandler)
-	r.GET("/displaymode", display.DisplayMode)
-	r.GET("/", display.ShowIndexPage)
+	dev := flag.Bool("dev", false, "read templates and static files from disk, reloading templates when they change")
+	flag.Parse()
+	config.Load()
+	logging.Configure(config.LogFormat, config.LogLevel)
+	if err := audit.Open(config.AuditFile, int64(config.AuditMaxKilobytes)*1024, config.AuditBackups); err != nil {
+		logging.Error("Audit trail not available; events will only be logged", "file", config.AuditFile, "error", err)
+	}
+	if config.SessionDir != "" {
+		store, err := persist.OpenFileStore(config.SessionDir)
+		if err != nil {
+			logging.Error("Sessions will not survive a restart", "error", err)
+		} else {
+			persist.Sessions = store
+		}
+	}
+	if *dev {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		logging.Error("Could not set the trusted proxies; trusting none", "proxies", config.TrustedProxies, "error", err)
+		r.SetTrustedProxies(nil)
+	}
+	r.Use(logging.RequestID, metrics.Middleware, gin.Recovery())
+	metrics.NewGaugeFunc("capfront_active_sessions", "Users currently logged in to this frontend.", activeSessions)
+	loadAssets(r, *dev)
+	logging.Info("Welcome to capitalism")
+
+	// These endpoints must work even when the server is down
+	r.GET("/healthz", display.Healthz)
+	r.GET("/readyz", display.Readyz)
+	r.GET("/metrics", metrics.Handler)
+
+	// Everything else needs the server
+	backend := r.Group("/", display.RequireBackend, display.SerialiseUser)
+	backend.GET("/action/:action", display.ReadOnlyGuard, display.SweepGuard, display.ActionHandler)
+	backend.GET("/commodities", display.ShowCommodities)
+	backend.GET("/industries", display.ShowIndustries)
+	backend.GET("/classes", display.ShowClasses)
+	backend.GET("/industry_stocks", display.ShowIndustryStocks)
+	backend.GET("/class_stocks", display.ShowClassStocks)
+	backend.GET("/industry/:id", display.ShowIndustry)
+	backend.GET("/commodity/:id", display.ShowCommodity)
+	backend.GET("/class/:id", display.ShowClass)
+	backend.GET("/trace", display.ShowTrace)
+	backend.GET("/iotable", display.ShowIOTable)
+	backend.GET("/iotable/csv", display.IOTableCSV)
+	backend.GET("/schema", display.ShowSchema)
+	backend.GET("/moneyflow", display.ShowMoneyFlows)
+	backend.GET("/crosscheck", display.ShowCrossCheck)
+	backend.GET("/compare", display.ShowComparison)
+	backend.GET("/compare/csv", display.ComparisonCSV)
+	backend.GET("/sweep", display.ShowSweep)
+	backend.POST("/sweep", display.ReadOnlyGuard, display.SweepGuard, display.StartSweep)
+	backend.GET("/sweep/cancel", display.ReadOnlyGuard, display.CancelSweep)
+	backend.GET("/sweep/clear", display.ReadOnlyGuard, display.ClearSweep)
+	backend.GET("/sweep/csv", display.SweepCSV)
+	backend.GET("/admin/dashboard", display.AdminDashboard)
+	backend.GET("/admin/reset", display.ReadOnlyGuard, display.SweepGuard, display.AdminReset)
+	backend.GET("/admin/view/:username", display.AdminViewUser)
+	backend.GET("/admin/stopviewing", display.AdminStopViewing)
+	backend.POST("/admin/quota/:username", display.ReadOnlyGuard, display.AdminSetQuota)
+	backend.GET("/admin/audit", display.AdminAudit)
+	backend.GET("/admin/resync", display.ReadOnlyGuard, display.SweepGuard, display.AdminResyncAll)
+	backend.GET("/admin/reload", display.ReadOnlyGuard, display.AdminReloadShared)
+	backend.GET("/admin/templates", display.AdminTemplateEditor)
+	backend.POST("/admin/templates", display.ReadOnlyGuard, display.AdminTemplateEdit)
+	backend.POST("/admin/templates/import", display.ReadOnlyGuard, display.AdminTemplateImport)
+	backend.GET("/login", display.CaptureLoginRequest)
+	backend.POST("/user/login", display.HandleLoginRequest)
+	backend.GET("/logout", display.ClientLogoutRequest)
+	backend.GET("/register", display.CaptureRegisterRequest)
+	backend.POST("/user/register", display.HandleRegisterRequest)
+	backend.GET("/user/password", display.CapturePasswordChangeRequest)
+	backend.POST("/user/password", display.ReadOnlyGuard, display.HandlePasswordChangeRequest)
+	backend.GET("/user/resync", display.ReadOnlyGuard, display.SweepGuard, display.UserResync)
+	backend.GET("/user/whathappened", display.WhatHappenedJSON)
+	backend.GET("/user/create/:id", display.ReadOnlyGuard, display.SweepGuard, display.CreateSimulation)
+	backend.GET("/user/customise/:id", display.ReadOnlyGuard, display.SweepGuard, display.CaptureCloneRequest)
+	backend.POST("/user/customise/:id", display.ReadOnlyGuard, display.SweepGuard, display.HandleCloneRequest)
+	backend.GET("/user/dashboard", display.UserDashboard)
+	backend.GET("/user/switch/:id", display.ReadOnlyGuard, display.SweepGuard, display.SwitchSimulation)
+	backend.GET("/user/delete/:id", display.ReadOnlyGuard, display.SweepGuard, display.DeleteSimulation)
+	backend.GET("/user/restart/:id", display.ReadOnlyGuard, display.SweepGuard, display.RestartSimulation)
+	backend.GET("/index/", display.ShowIndexPage)
+	backend.GET("/data/", display.DataHandler)
+	backend.GET("/displaymode", display.DisplayMode)
+	backend.GET("/", display.ShowIndexPage)
 	Initialise()
-	r.Run() // Run the server
+	serve(r)
+}
+
+// Runs the server until it is told to stop by SIGINT or SIGTERM.
+// Then stops accepting requests, lets those in progress finish
+// (including any refreshes from the remote server), stops any sweeps, saves users' sessions
+// and flushes the audit trail.
+func serve(handler http.Handler) {
+	server := &http.Server{
+		Addr:         config.Address,
+		Handler:      handler,
+		ReadTimeout:  time.Duration(config.ReadTimeoutSeconds) * time.Second,
+		WriteTimeout: time.Duration(config.WriteTimeoutSeconds) * time.Second,
+		IdleTimeout:  time.Duration(config.IdleTimeoutSeconds) * time.Second,
+	}
+
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	failed := make(chan error, 1)
+	go func() {
+		var err error
+		if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+			logging.Info("Serving HTTPS", "address", config.Address)
+			err = server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile)
+		} else {
+			logging.Info("Serving HTTP", "address", config.Address)
+			err = server.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		logging.Error("Server stopped unexpectedly", "error", err)
+	case <-stop.Done():
+		logging.Info("Shutting down")
+	}
 
+	timeout := time.Duration(config.ShutdownTimeoutSeconds) * time.Second
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(ctx); err != nil {
+		logging.Warn("Some requests did not finish before shutdown", "error", err)
+	}
+	if !sweep.Shutdown(timeout) {
+		logging.Warn("Some sweeps did not stop before shutdown")
+	}
+	if !api.WaitForRefreshes(timeout) {
+		logging.Warn("Some refreshes did not finish before shutdown")
+	}
+	if persist.Sessions != nil {
+		persist.SaveAll()
+		persist.Sessions.Close()
+	}
+	if audit.Log != nil {
+		audit.Log.Close()
+	}
+	logging.Info("Shutdown complete")
 }
@@ -0,0 +1,153 @@
+// metrics.capfront.go
+// the metrics that this frontend collects, and the helpers that collect them
+
+package metrics
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"capfront/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+var HTTPRequests = NewCounterVec("capfront_http_requests_total",
+	"HTTP requests served, by route, method and status.", "route", "method", "status")
+
+var HTTPDuration = NewHistogramVec("capfront_http_request_duration_seconds",
+	"Time taken to serve HTTP requests, by route.", DurationBuckets, "route")
+
+var BackendRequests = NewCounterVec("capfront_backend_requests_total",
+	"Requests to the backend, by endpoint and outcome (success, failure, timeout).", "endpoint", "outcome")
+
+var BackendDuration = NewHistogramVec("capfront_backend_request_duration_seconds",
+	"Time taken by the backend to respond, by endpoint.", DurationBuckets, "endpoint")
+
+var Refreshes = NewCounterVec("capfront_refresh_total",
+	"Attempts to refresh a user's tables from the backend, by outcome.", "outcome")
+
+var RefreshTables = NewCounterVec("capfront_refresh_tables_total",
+	"Tables fetched during refreshes, by result (updated, unchanged).", "result")
+
+var Actions = NewCounterVec("capfront_actions_total",
+	"Simulation actions requested by users, by action and outcome.", "action", "outcome")
+
+// Middleware that counts and times every request.
+// Requests are labelled with the route pattern (eg /industry/:id) rather than
+// the actual path, so that there is one series per route, not per object.
+func Middleware(ctx *gin.Context) {
+	start := time.Now()
+	ctx.Next()
+	route := ctx.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	HTTPRequests.Inc(route, ctx.Request.Method, strconv.Itoa(ctx.Writer.Status()))
+	HTTPDuration.Observe(time.Since(start).Seconds(), route)
+}
+
+// Serves all the metrics in the Prometheus text format.
+// The metrics reveal who is using the frontend and how, so they are not public:
+// if config.MetricsToken is set the scraper must present it, otherwise only
+// requests from this machine are answered.
+func Handler(ctx *gin.Context) {
+	if !mayScrape(ctx.Request) {
+		ctx.String(http.StatusForbidden, "Forbidden")
+		return
+	}
+	ctx.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	ctx.Status(http.StatusOK)
+	WriteAll(ctx.Writer)
+}
+
+// Decides whether a request for the metrics should be answered
+func mayScrape(r *http.Request) bool {
+	if config.MetricsToken != "" {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(presented), []byte(config.MetricsToken)) == 1
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Records one request to the backend.
+// relativePath is the path that was requested; started is when the request was sent;
+// err is the error from sending it, if any; status is the HTTP status the backend returned.
+func ObserveBackend(relativePath string, started time.Time, err error, status int) {
+	endpoint := EndpointLabel(relativePath)
+	outcome := "success"
+	var netErr net.Error
+	switch {
+	case err != nil && errors.As(err, &netErr) && netErr.Timeout():
+		outcome = "timeout"
+	case err != nil || (status != http.StatusOK && status != http.StatusNotModified):
+		outcome = "failure"
+	}
+	BackendRequests.Inc(endpoint, outcome)
+	BackendDuration.Observe(time.Since(started).Seconds(), endpoint)
+}
+
+// Converts a backend path into a label with one value per endpoint,
+// by replacing numeric ids and usernames with placeholders.
+// For example users/alice becomes users/:username and simulations/delete/3 becomes simulations/delete/:id.
+// The action in action/<action> comes from the URL the user asked for, so anything
+// but an action of the circuit (or the admin's reset) becomes action/unknown.
+func EndpointLabel(relativePath string) string {
+	segments := strings.Split(strings.Trim(relativePath, "/"), "/")
+	for i, segment := range segments {
+		if _, err := strconv.Atoi(segment); err == nil {
+			segments[i] = ":id"
+		} else if i == 1 && segments[0] == "users" && segment != "clone" && segment != "" {
+			segments[i] = ":username"
+		} else if i == 1 && segments[0] == "action" && !circuitActions[segment] && segment != "reset" {
+			segments[i] = "unknown"
+		}
+	}
+	if len(segments) > 2 && segments[0] == "action" {
+		segments = segments[:2]
+	}
+	return strings.Join(segments, "/")
+}
+
+// Records the outcome of a refresh of a user's tables
+func ObserveRefresh(ok bool) {
+	if ok {
+		Refreshes.Inc("success")
+	} else {
+		Refreshes.Inc("failure")
+	}
+}
+
+// Records how many of the tables fetched in one refresh had changed
+func ObserveRefreshTables(updated int, unchanged int) {
+	RefreshTables.Add(float64(updated), "updated")
+	RefreshTables.Add(float64(unchanged), "unchanged")
+}
+
+// The actions of the circuit. Any other action is counted as "unknown",
+// so that a user cannot create a new series by typing a made-up URL.
+var circuitActions = map[string]bool{
+	"demand": true, "supply": true, "trade": true, "produce": true, "consume": true, "invest": true,
+}
+
+// Records an action requested by a user
+func ObserveAction(action string, err error) {
+	if !circuitActions[action] {
+		action = "unknown"
+	}
+	if err != nil {
+		Actions.Inc(action, "failure")
+	} else {
+		Actions.Inc(action, "success")
+	}
+}
@@ -0,0 +1,99 @@
+// metrics.capfront_test.go
+// tests of the helpers that collect the frontend's metrics, and of who may read them
+
+package metrics
+
+import (
+	"capfront/config"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEndpointLabel(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"users/alice", "users/:username"},
+		{"users/", "users"},
+		{"users/clone/3", "users/clone/:id"},
+		{"simulations/delete/12", "simulations/delete/:id"},
+		{"/commodities/", "commodities"},
+		{"action/demand", "action/demand"},
+		{"action/reset", "action/reset"},
+		{"action/made-up", "action/unknown"},
+		{"action/made-up/more/segments", "action/unknown"},
+		{"action/demand/1", "action/demand"},
+	}
+	for _, test := range tests {
+		if got := EndpointLabel(test.path); got != test.want {
+			t.Errorf("EndpointLabel(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestObserveAction(t *testing.T) {
+	tests := []struct {
+		action  string
+		err     error
+		label   string
+		outcome string
+	}{
+		{"demand", nil, "demand", "success"},
+		{"invest", errors.New("refused"), "invest", "failure"},
+		{"borrow", nil, "unknown", "success"},
+		{"", errors.New("refused"), "unknown", "failure"},
+	}
+	for _, test := range tests {
+		before := Actions.Value(test.label, test.outcome)
+		ObserveAction(test.action, test.err)
+		if got := Actions.Value(test.label, test.outcome) - before; got != 1 {
+			t.Errorf("ObserveAction(%q, %v) added %g to (%s, %s), want 1", test.action, test.err, got, test.label, test.outcome)
+		}
+	}
+	if got := Actions.Value("borrow", "success"); got != 0 {
+		t.Errorf("an unknown action has its own series")
+	}
+}
+
+func TestHandlerAccess(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		remoteAddr    string
+		authorization string
+		want          int
+	}{
+		{"no token, loopback", "", "127.0.0.1:5000", "", http.StatusOK},
+		{"no token, IPv6 loopback", "", "[::1]:5000", "", http.StatusOK},
+		{"no token, remote", "", "192.0.2.1:5000", "", http.StatusForbidden},
+		{"no token, bad address", "", "nonsense", "", http.StatusForbidden},
+		{"token, right", "secret", "192.0.2.1:5000", "Bearer secret", http.StatusOK},
+		{"token, wrong", "secret", "192.0.2.1:5000", "Bearer guess", http.StatusForbidden},
+		{"token, bare", "secret", "192.0.2.1:5000", "secret", http.StatusForbidden},
+		{"token, missing", "secret", "127.0.0.1:5000", "", http.StatusForbidden},
+	}
+	gin.SetMode(gin.TestMode)
+	oldToken := config.MetricsToken
+	t.Cleanup(func() { config.MetricsToken = oldToken })
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.MetricsToken = test.token
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest("GET", "/metrics", nil)
+			ctx.Request.RemoteAddr = test.remoteAddr
+			if test.authorization != "" {
+				ctx.Request.Header.Set("Authorization", test.authorization)
+			}
+			Handler(ctx)
+			if recorder.Code != test.want {
+				t.Errorf("status %d, want %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
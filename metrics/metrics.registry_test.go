@@ -0,0 +1,75 @@
+// metrics.registry_test.go
+// tests of the Prometheus text written by the registry
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCounterVecText(t *testing.T) {
+	c := NewCounterVec("test_counter_total", "A counter for testing.", "kind", "outcome")
+	c.Inc("b", "success")
+	c.Add(2.5, "a", "failure")
+	c.Inc("quote\"and\nnewline", "success")
+	var out bytes.Buffer
+	c.write(&out)
+	want := `# HELP test_counter_total A counter for testing.
+# TYPE test_counter_total counter
+test_counter_total{kind="a",outcome="failure"} 2.5
+test_counter_total{kind="b",outcome="success"} 1
+test_counter_total{kind="quote\"and\nnewline",outcome="success"} 1
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	if got := c.Value("a", "failure"); got != 2.5 {
+		t.Errorf("Value = %g, want 2.5", got)
+	}
+}
+
+func TestHistogramVecText(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "A histogram for testing.", []float64{0.1, 1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(3, "/a")
+	var out bytes.Buffer
+	h.write(&out)
+	want := `# HELP test_seconds A histogram for testing.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/a",le="0.1"} 1
+test_seconds_bucket{route="/a",le="1"} 2
+test_seconds_bucket{route="/a",le="+Inf"} 3
+test_seconds_sum{route="/a"} 3.55
+test_seconds_count{route="/a"} 3
+`
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestGaugeFuncText(t *testing.T) {
+	value := 1.0
+	g := NewGaugeFunc("test_gauge", "A gauge for testing.", func() float64 { return value })
+	value = 7
+	var out bytes.Buffer
+	g.write(&out)
+	want := "# HELP test_gauge A gauge for testing.\n# TYPE test_gauge gauge\ntest_gauge 7\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+// Everything registered, including the frontend's own metrics, appears in WriteAll
+func TestWriteAll(t *testing.T) {
+	NewCounterVec("test_registered_total", "Registered for testing.").Inc()
+	var out bytes.Buffer
+	WriteAll(&out)
+	for _, name := range []string{"capfront_http_requests_total", "capfront_backend_requests_total", "test_registered_total 1"} {
+		if !strings.Contains(out.String(), name) {
+			t.Errorf("WriteAll did not write %s", name)
+		}
+	}
+}
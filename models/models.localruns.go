@@ -0,0 +1,103 @@
+// models.localruns.go
+// the simulations that the local engine (package engine) has changed.
+// The server knows nothing of these changes, so each is kept apart from the tables
+// downloaded from the server, and laid back over them after every download.
+
+package models
+
+// The objects of one simulation as the local engine left them
+type LocalRun struct {
+	Simulation        Simulation
+	CommodityList     []Commodity
+	IndustryList      []Industry
+	ClassList         []Class
+	IndustryStockList []Industry_Stock
+	ClassStockList    []Class_Stock
+	TraceList         []Trace
+}
+
+// Keeps the objects of simulation id, as they now are in the user's tables, as a local run.
+// Replaces any local run of that simulation kept before.
+func (u *UserData) KeepLocalRun(id int) {
+	run := LocalRun{
+		CommodityList:     rowsOf(u.CommodityList, func(c Commodity) bool { return int(c.Simulation_id) == id }),
+		IndustryList:      rowsOf(u.IndustryList, func(i Industry) bool { return int(i.Simulation_id) == id }),
+		ClassList:         rowsOf(u.ClassList, func(c Class) bool { return int(c.Simulation_id) == id }),
+		IndustryStockList: rowsOf(u.IndustryStockList, func(s Industry_Stock) bool { return s.Simulation_id == id }),
+		ClassStockList:    rowsOf(u.ClassStockList, func(s Class_Stock) bool { return s.Simulation_id == id }),
+		TraceList:         rowsOf(u.TraceList, func(t Trace) bool { return t.Simulation_id == id }),
+	}
+	for _, s := range u.SimulationList {
+		if s.Id == id {
+			run.Simulation = s
+		}
+	}
+	if u.LocalRuns == nil {
+		u.LocalRuns = make(map[int]LocalRun)
+	}
+	u.LocalRuns[id] = run
+}
+
+// Forgets the local run of simulation id, if there is one
+func (u *UserData) ForgetLocalRun(id int) {
+	delete(u.LocalRuns, id)
+}
+
+// Lays the local runs over the user's tables, replacing whatever the server sent
+// for the objects of those simulations. Called after every download, so that the
+// server's stale copy of a simulation never replaces what the local engine did with it.
+// A simulation that the server no longer has is forgotten.
+func (u *UserData) ApplyLocalRuns() {
+	for id, run := range u.LocalRuns {
+		i := -1
+		for j := range u.SimulationList {
+			if u.SimulationList[j].Id == id {
+				i = j
+			}
+		}
+		if i < 0 {
+			delete(u.LocalRuns, id)
+			continue
+		}
+		u.SimulationList[i] = run.Simulation
+		u.CommodityList = replaceRows(u.CommodityList, run.CommodityList, func(c Commodity) bool { return int(c.Simulation_id) == id })
+		u.IndustryList = replaceRows(u.IndustryList, run.IndustryList, func(i Industry) bool { return int(i.Simulation_id) == id })
+		u.ClassList = replaceRows(u.ClassList, run.ClassList, func(c Class) bool { return int(c.Simulation_id) == id })
+		u.IndustryStockList = replaceRows(u.IndustryStockList, run.IndustryStockList, func(s Industry_Stock) bool { return s.Simulation_id == id })
+		u.ClassStockList = replaceRows(u.ClassStockList, run.ClassStockList, func(s Class_Stock) bool { return s.Simulation_id == id })
+		u.TraceList = replaceRows(u.TraceList, run.TraceList, func(t Trace) bool { return t.Simulation_id == id })
+	}
+}
+
+// helper function returning a copy of the rows of list that belong to a simulation, as decided by belongs
+func rowsOf[T any](list []T, belongs func(T) bool) []T {
+	var rows []T
+	for _, row := range list {
+		if belongs(row) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// helper function that replaces the rows of list that belong with rows.
+// The new rows go where the first of the old ones was, or at the end if there were none,
+// so that the other simulations' rows stay in the order the server sent them.
+func replaceRows[T any](list []T, rows []T, belongs func(T) bool) []T {
+	result := make([]T, 0, len(list)+len(rows))
+	placed := false
+	for _, row := range list {
+		if !belongs(row) {
+			result = append(result, row)
+			continue
+		}
+		if !placed {
+			result = append(result, rows...)
+			placed = true
+		}
+	}
+	if !placed {
+		result = append(result, rows...)
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+// models.localruns_test.go
+// tests that what the local engine did survives a download from the server
+
+package models
+
+import "testing"
+
+// helper that makes a user with two simulations, each with one commodity, as the server would send them
+func serverTables() UserData {
+	return UserData{
+		UserName:       "tester",
+		SimulationList: []Simulation{{Id: 1, Time_Stamp: 1}, {Id: 2, Time_Stamp: 1}},
+		CommodityList: []Commodity{
+			{Id: 10, Simulation_id: 1, Size: 100},
+			{Id: 20, Simulation_id: 2, Size: 200},
+		},
+		TraceList: []Trace{{Id: 1, Simulation_id: 1}},
+	}
+}
+
+func TestApplyLocalRuns(t *testing.T) {
+	user := serverTables()
+	user.SimulationList[0].Time_Stamp = 2
+	user.CommodityList[0].Size = 150
+	user.TraceList = append(user.TraceList, Trace{Id: 2, Simulation_id: 1})
+	user.KeepLocalRun(1)
+
+	// The server sends its copy again, which knows nothing of the local run
+	fresh := serverTables()
+	user.SimulationList, user.CommodityList, user.TraceList = fresh.SimulationList, fresh.CommodityList, fresh.TraceList
+	user.ApplyLocalRuns()
+
+	if user.SimulationList[0].Time_Stamp != 2 || user.CommodityList[0].Size != 150 || len(user.TraceList) != 2 {
+		t.Errorf("the local run was lost: %+v", user)
+	}
+	if user.CommodityList[1].Id != 20 || user.CommodityList[1].Size != 200 {
+		t.Errorf("the other simulation was changed: %+v", user.CommodityList)
+	}
+}
+
+func TestApplyLocalRunsForgetsDeletedSimulations(t *testing.T) {
+	user := serverTables()
+	user.KeepLocalRun(2)
+	user.SimulationList = user.SimulationList[:1]
+	user.CommodityList = user.CommodityList[:1]
+	user.ApplyLocalRuns()
+	if len(user.LocalRuns) != 0 || len(user.CommodityList) != 1 {
+		t.Errorf("the local run of a deleted simulation was kept: %d runs, %d commodities", len(user.LocalRuns), len(user.CommodityList))
+	}
+}
+
+func TestForgetLocalRun(t *testing.T) {
+	user := serverTables()
+	user.KeepLocalRun(1)
+	user.CommodityList[0].Size = 999
+	user.ForgetLocalRun(1)
+	user.ApplyLocalRuns()
+	if user.CommodityList[0].Size != 999 {
+		t.Error("a forgotten local run was applied")
+	}
+}
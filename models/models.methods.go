@@ -4,6 +4,7 @@
 package models
 
 import (
+	"capfront/config"
 	"strconv"
 )
 
@@ -76,10 +77,10 @@ var NotFoundCommodity = Commodity{
 // WAS err = db.SDB.QueryRowx("SELECT * FROM stocks where Owner_Id = ? AND Usage_type =?", industry.Id, "Money").StructScan(&stock)
 func (industry Industry) MoneyStock() Industry_Stock {
 	username := industry.UserName
-	stockList := (Users[username].IndustryStockList)
+	stockList := (User(username).IndustryStockList)
 	for i := 0; i < len(stockList); i++ {
 		s := stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Money`) {
+		if (s.Industry_id == industry.Id) && (s.Usage_type == MoneyUsage) {
 			return s
 		}
 	}
@@ -90,10 +91,10 @@ func (industry Industry) MoneyStock() Industry_Stock {
 // WAS 	err = db.SDB.QueryRowx("SELECT * FROM stocks where Owner_Id = ? AND Usage_type =?", industry.Id, "Sales").StructScan(&stock)
 func (industry Industry) SalesStock() Industry_Stock {
 	username := industry.UserName
-	stockList := (Users[username].IndustryStockList)
+	stockList := (User(username).IndustryStockList)
 	for i := 0; i < len(stockList); i++ {
 		s := &stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Sales`) {
+		if (s.Industry_id == industry.Id) && (s.Usage_type == SalesUsage) {
 			return *s
 		}
 	}
@@ -105,10 +106,10 @@ func (industry Industry) SalesStock() Industry_Stock {
 // bit of a botch to use the name of the commodity as a search term
 func (industry Industry) VariableCapital() Industry_Stock {
 	username := industry.UserName
-	stockList := (Users[username].IndustryStockList)
+	stockList := (User(username).IndustryStockList)
 	for i := 0; i < len(stockList); i++ {
 		s := &stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Production`) && (s.CommodityName() == "Labour Power") {
+		if (s.Industry_id == industry.Id) && (s.Usage_type == ProductionUsage) && (s.CommodityName() == "Labour Power") {
 			return *s
 		}
 	}
@@ -125,10 +126,10 @@ func (industry Industry) OutputCommodity() *Commodity {
 // was 	query := `SELECT stocks.* FROM stocks INNER JOIN commodities ON stocks.commodity_id = commodities.id where stocks.owner_id = ? AND Usage_type ="Production" AND commodities.name="Means of Production"`
 func (industry Industry) ConstantCapital() Industry_Stock {
 	username := industry.UserName
-	stockList := (Users[username].IndustryStockList)
+	stockList := (User(username).IndustryStockList)
 	for i := 0; i < len(stockList); i++ {
 		s := &stockList[i]
-		if (s.Industry_id == industry.Id) && (s.Usage_type == `Production`) && (s.CommodityName() == "Means of Production") {
+		if (s.Industry_id == industry.Id) && (s.Usage_type == ProductionUsage) && (s.CommodityName() == "Means of Production") {
 			return *s
 		}
 	}
@@ -147,11 +148,11 @@ func (industry Industry) ConstantCapital() Industry_Stock {
 // was 	err = db.SDB.QueryRowx("SELECT * FROM stocks where Owner_Id = ? AND Usage_type =?", class.Id, "Sales").StructScan(&stock)
 func (class Class) MoneyStock() Class_Stock {
 	username := class.UserName
-	stockList := (Users[username].ClassStockList)
+	stockList := (User(username).ClassStockList)
 
 	for i := 0; i < len(stockList); i++ {
 		s := &stockList[i]
-		if (s.Class_id == class.Id) && (s.Usage_type == `Money`) {
+		if (s.Class_id == class.Id) && (s.Usage_type == MoneyUsage) {
 			return *s
 		}
 	}
@@ -161,10 +162,10 @@ func (class Class) MoneyStock() Class_Stock {
 // returns the sales stock of the given class
 func (class Class) SalesStock() Class_Stock {
 	username := class.UserName
-	stockList := (Users[username].ClassStockList)
+	stockList := (User(username).ClassStockList)
 	for i := 0; i < len(stockList); i++ {
 		s := &stockList[i]
-		if (s.Class_id == class.Id) && (s.Usage_type == `Sales`) {
+		if (s.Class_id == class.Id) && (s.Usage_type == SalesUsage) {
 			return *s
 		}
 	}
@@ -176,11 +177,11 @@ func (class Class) SalesStock() Class_Stock {
 // WAS 	query := `SELECT stocks.* FROM stocks INNER JOIN commodities ON stocks.commodity_id = commodities.id where stocks.owner_id = ? AND Usage_type ="Consumption" AND commodities.name="Consumption"`
 func (class Class) ConsumerGood() Class_Stock {
 	username := class.UserName
-	stockList := (Users[username].ClassStockList)
+	stockList := (User(username).ClassStockList)
 
 	for i := 0; i < len(stockList); i++ {
 		s := &stockList[i]
-		if (s.Class_id == class.Id) && (s.Usage_type == `Consumption`) {
+		if (s.Class_id == class.Id) && (s.Usage_type == ConsumptionUsage) {
 			return *s
 		}
 	}
@@ -192,7 +193,7 @@ func (class Class) ConsumerGood() Class_Stock {
 // fetches the name of the owner of this stock
 func (s Industry_Stock) OwnerName() string {
 	username := s.UserName
-	industryList := (Users[username].IndustryList)
+	industryList := (User(username).IndustryList)
 	for i := 0; i < len(industryList); i++ {
 		ind := &industryList[i]
 		if s.Industry_id == ind.Id {
@@ -206,7 +207,7 @@ func (s Industry_Stock) OwnerName() string {
 // WAS 	rows, err := db.SDB.Queryx("SELECT * FROM commodities where Id = ?", i.Commodity_id)
 func (s Industry_Stock) CommodityName() string {
 	username := s.UserName
-	commodityList := (Users[username].CommodityList)
+	commodityList := (User(username).CommodityList)
 	for i := 0; i < len(commodityList); i++ {
 		c := commodityList[i]
 		if s.Commodity_id == c.Id {
@@ -220,7 +221,7 @@ func (s Industry_Stock) CommodityName() string {
 // WAS 	rows, err := db.SDB.Queryx("SELECT * FROM commodities where Id = ?", i.Commodity_id)
 func (s Industry_Stock) Commodity() *Commodity {
 	username := s.UserName
-	commodityList := (Users[username].CommodityList)
+	commodityList := (User(username).CommodityList)
 	for i := 0; i < len(commodityList); i++ {
 		c := commodityList[i]
 		if s.Commodity_id == c.Id {
@@ -253,7 +254,7 @@ func (s Simulation) Link() string {
 // fetches the industry that owns this industry stock
 // If it has none (an error, but we need to diagnose it) return nil.
 func (s Industry_Stock) Industry() *Industry {
-	industryList := (Users[s.UserName].IndustryList)
+	industryList := (User(s.UserName).IndustryList)
 	for i := 0; i < len(industryList); i++ {
 		ind := &industryList[i]
 		if s.Industry_id == ind.Id {
@@ -278,7 +279,7 @@ func (s Industry_Stock) IndustryName() string {
 // fetches the class that owns this Class_stock
 // If it has none (an error, but we need to diagnose it) return nil.
 func (s Class_Stock) Class() *Class {
-	classList := (Users[s.UserName].ClassList)
+	classList := (User(s.UserName).ClassList)
 	for i := 0; i < len(classList); i++ {
 		ind := &classList[i]
 		if s.Class_id == ind.Id {
@@ -302,7 +303,7 @@ func (s Class_Stock) ClassName() string {
 // Return "UNKNOWN COMMODITY" if this is not found.
 func (s Class_Stock) CommodityName() string {
 	username := s.UserName
-	commodityList := (Users[username].CommodityList)
+	commodityList := (User(username).CommodityList)
 	for i := 0; i < len(commodityList); i++ {
 		c := commodityList[i]
 		if s.Commodity_id == c.Id {
@@ -311,3 +312,46 @@ func (s Class_Stock) CommodityName() string {
 	}
 	return `UNKNOWN COMMODITY`
 }
+
+// METHODS OF USERS
+
+// Gives the user, and every object in their tables, the name name.
+// The methods of the objects use this name to find the tables they belong to.
+func (u *UserData) SetOwner(name string) {
+	u.UserName = name
+	for i := range u.SimulationList {
+		u.SimulationList[i].UserName = name
+	}
+	for i := range u.CommodityList {
+		u.CommodityList[i].UserName = name
+	}
+	for i := range u.IndustryList {
+		u.IndustryList[i].UserName = name
+	}
+	for i := range u.ClassList {
+		u.ClassList[i].UserName = name
+	}
+	for i := range u.IndustryStockList {
+		u.IndustryStockList[i].UserName = name
+	}
+	for i := range u.ClassStockList {
+		u.ClassStockList[i].UserName = name
+	}
+	for i := range u.TraceList {
+		u.TraceList[i].UserName = name
+	}
+}
+
+// The number of simulations this user may create.
+// This is the admin's override for this user if there is one, otherwise the configured default.
+func (u UserData) Quota() int {
+	if u.SimulationQuota != nil {
+		return *u.SimulationQuota
+	}
+	return config.SimulationQuota
+}
+
+// True if this user has already created as many simulations as the quota allows.
+func (u UserData) QuotaExhausted() bool {
+	return len(u.SimulationList) >= u.Quota()
+}
@@ -7,6 +7,11 @@
 
 package models
 
+import (
+	"slices"
+	"sync"
+)
+
 // This is used to wrap requests to the API on the remote server
 type RequestData struct {
 	User string `json:"user"`
@@ -71,6 +76,22 @@ type Commodity struct {
 	Investment_Proportion       float32 `json:"investment_proportion"`
 }
 
+// The origins of commodities, as the server names them.
+// Commodities of social origin (labour power) and money are not produced by any industry.
+const (
+	IndustrialOrigin = "INDUSTRIAL"
+	SocialOrigin     = "SOCIAL"
+	MoneyOrigin      = "MONEY"
+)
+
+// What commodities are used for, as the server names it in Commodity.Usage.
+// Not to be confused with the usage types of stocks, below.
+const (
+	ProductiveCommodity  = "PRODUCTIVE"  // means of production and labour power
+	ConsumptionCommodity = "CONSUMPTION" // consumption goods
+	MoneyCommodity       = "MONEY"
+)
+
 type Industry struct {
 	Id                 int    `json:"id" gorm:"primary_key"`
 	Name               string `json:"name"`
@@ -100,6 +121,14 @@ type Class struct {
 	Assets              float32 `json:"assets"`
 }
 
+// The usage types of stocks, as the server names them
+const (
+	MoneyUsage       = "Money"
+	SalesUsage       = "Sales"
+	ProductionUsage  = "Production"
+	ConsumptionUsage = "Consumption"
+)
+
 type Industry_Stock struct {
 	Id            int     `json:"id" gorm:"primary_key"`
 	Simulation_id int     `json:"simulation_id" `
@@ -132,7 +161,23 @@ type Class_Stock struct {
 // This list of templates is common to all users.
 // It would normally change only when the database is reset from
 // immutable fixtures using Refresh().
-// It is initialized when this frontend restarts.
-// In future there should be some procedure for adding new templates
-// or editing existing ones.
-var TemplateList []Simulation
+// It is kept up to date by the cache api.Templates, which reloads it
+// from time to time (or when the admin asks).
+// The admin writes new templates with the template editor (see package authoring).
+// It is read by requests while the cache reloads it, so use Templates and SetTemplates, which lock it.
+var templateList []Simulation
+var templatesLock sync.RWMutex
+
+// Returns a copy of the list of templates
+func Templates() []Simulation {
+	templatesLock.RLock()
+	defer templatesLock.RUnlock()
+	return slices.Clone(templateList)
+}
+
+// Replaces the list of templates
+func SetTemplates(list []Simulation) {
+	templatesLock.Lock()
+	defer templatesLock.Unlock()
+	templateList = list
+}
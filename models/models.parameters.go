@@ -0,0 +1,112 @@
+// models.parameters.go
+// the parameters of a simulation that a user may change when cloning a template
+
+package models
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// The values of the enumerated parameters that we know the server accepts.
+// The server is the authority: any value used by a template it has sent us is accepted too
+// (see LabourSupplyOptions and friends), so a value it adds later is not refused here.
+var LabourSupplyResponses = []string{"FLEXIBLE", "FIXED"}
+var PriceResponseTypes = []string{"VALUES", "EQUALISE", "DYNAMIC"}
+var MeltResponseTypes = []string{"VALUE", "PRICE"}
+
+// The values that Labour_Supply_Demand may take
+func LabourSupplyOptions() []string {
+	return enumOptions(LabourSupplyResponses, func(s Simulation) string { return s.Labour_Supply_Demand })
+}
+
+// The values that Price_Response_Type may take
+func PriceResponseOptions() []string {
+	return enumOptions(PriceResponseTypes, func(s Simulation) string { return s.Price_Response_Type })
+}
+
+// The values that Melt_Response_Type may take
+func MeltResponseOptions() []string {
+	return enumOptions(MeltResponseTypes, func(s Simulation) string { return s.Melt_Response_Type })
+}
+
+// helper function that adds, to the known values of a parameter, those used by the templates
+func enumOptions(known []string, field func(Simulation) string) []string {
+	options := slices.Clone(known)
+	for _, template := range Templates() {
+		if value := field(template); value != "" && !slices.Contains(options, value) {
+			options = append(options, value)
+		}
+	}
+	return options
+}
+
+// helper function reporting whether value is a number between low and high.
+// NaN and the infinities are not.
+func outside(value float32, low float32, high float32) bool {
+	v := float64(value)
+	return math.IsNaN(v) || math.IsInf(v, 0) || value < low || value > high
+}
+
+// The longest currency or quantity symbol we accept
+const MaxSymbolLength = 5
+
+// Parameters sent to the server with a clone request, overriding those of the template.
+// The JSON names are those the server uses for the corresponding fields of Simulation.
+type SimulationParameters struct {
+	Periods_Per_Year       float32 `json:"periods_per_year" yaml:"periods_per_year"`
+	Population_Growth_Rate float32 `json:"population_growth_rate" yaml:"population_growth_rate"`
+	Investment_Ratio       float32 `json:"investment_ratio" yaml:"investment_ratio"`
+	Labour_Supply_Demand   string  `json:"labour_supply_response" yaml:"labour_supply_response"`
+	Price_Response_Type    string  `json:"price_response_type" yaml:"price_response_type"`
+	Melt_Response_Type     string  `json:"melt_response_type" yaml:"melt_response_type"`
+	Currency_Symbol        string  `json:"currency_symbol" yaml:"currency_symbol"`
+	Quantity_Symbol        string  `json:"quantity_symbol" yaml:"quantity_symbol"`
+}
+
+// The parameters of a simulation (for example a template), as a starting point for editing
+func (s Simulation) Parameters() SimulationParameters {
+	return SimulationParameters{
+		Periods_Per_Year:       s.Periods_Per_Year,
+		Population_Growth_Rate: s.Population_Growth_Rate,
+		Investment_Ratio:       s.Investment_Ratio,
+		Labour_Supply_Demand:   s.Labour_Supply_Demand,
+		Price_Response_Type:    s.Price_Response_Type,
+		Melt_Response_Type:     s.Melt_Response_Type,
+		Currency_Symbol:        s.Currency_Symbol,
+		Quantity_Symbol:        s.Quantity_Symbol,
+	}
+}
+
+// Checks that every parameter is within its permitted range or is one of its permitted values.
+// Returns a message for each faulty parameter, keyed by its JSON name; empty if all is well.
+func (p SimulationParameters) Validate() map[string]string {
+	faults := make(map[string]string)
+	if outside(p.Periods_Per_Year, 1, 365) {
+		faults["periods_per_year"] = "Must be between 1 and 365"
+	}
+	if outside(p.Population_Growth_Rate, -1, 1) {
+		faults["population_growth_rate"] = "Must be between -1 and 1"
+	}
+	if outside(p.Investment_Ratio, 0, 1) {
+		faults["investment_ratio"] = "Must be between 0 and 1"
+	}
+	if options := LabourSupplyOptions(); !slices.Contains(options, p.Labour_Supply_Demand) {
+		faults["labour_supply_response"] = "Must be one of " + strings.Join(options, ", ")
+	}
+	if options := PriceResponseOptions(); !slices.Contains(options, p.Price_Response_Type) {
+		faults["price_response_type"] = "Must be one of " + strings.Join(options, ", ")
+	}
+	if options := MeltResponseOptions(); !slices.Contains(options, p.Melt_Response_Type) {
+		faults["melt_response_type"] = "Must be one of " + strings.Join(options, ", ")
+	}
+	if n := len([]rune(p.Currency_Symbol)); n < 1 || n > MaxSymbolLength {
+		faults["currency_symbol"] = fmt.Sprintf("Must be between 1 and %d characters", MaxSymbolLength)
+	}
+	if len([]rune(p.Quantity_Symbol)) > MaxSymbolLength {
+		faults["quantity_symbol"] = fmt.Sprintf("Must be no more than %d characters", MaxSymbolLength)
+	}
+	return faults
+}
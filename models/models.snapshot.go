@@ -0,0 +1,51 @@
+// models.snapshot.go
+// copies of a user's tables taken before and after each action,
+// so that we can show what the action did.
+
+package models
+
+import "time"
+
+// The number of actions whose snapshots are kept for each user: two full periods
+const HistoryLength = 12
+
+// A copy of the objects of one simulation at one moment
+type Snapshot struct {
+	Taken             time.Time
+	Simulation        int
+	CommodityList     []Commodity
+	IndustryList      []Industry
+	ClassList         []Class
+	IndustryStockList []Industry_Stock
+	ClassStockList    []Class_Stock
+}
+
+// What one action did: the simulation before it and after it
+type Transition struct {
+	Action string
+	Before Snapshot
+	After  Snapshot
+}
+
+// Copies the user's tables.
+// The copies do not share storage with the originals, which are overwritten by the next refresh.
+func (u *UserData) TakeSnapshot() Snapshot {
+	return Snapshot{
+		Taken:             time.Now(),
+		Simulation:        u.CurrentSimulation,
+		CommodityList:     append([]Commodity(nil), u.CommodityList...),
+		IndustryList:      append([]Industry(nil), u.IndustryList...),
+		ClassList:         append([]Class(nil), u.ClassList...),
+		IndustryStockList: append([]Industry_Stock(nil), u.IndustryStockList...),
+		ClassStockList:    append([]Class_Stock(nil), u.ClassStockList...),
+	}
+}
+
+// Records that the user has just carried out action, given a snapshot taken before it.
+// Only the most recent HistoryLength transitions are kept.
+func (u *UserData) RecordTransition(action string, before Snapshot) {
+	u.History = append(u.History, Transition{Action: action, Before: before, After: u.TakeSnapshot()})
+	if len(u.History) > HistoryLength {
+		u.History = append([]Transition(nil), u.History[len(u.History)-HistoryLength:]...)
+	}
+}
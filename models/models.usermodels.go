@@ -3,6 +3,12 @@
 
 package models
 
+import (
+	"slices"
+	"sort"
+	"sync"
+)
+
 const (
 	Quantity = iota
 	Value
@@ -19,6 +25,8 @@ type UserData struct {
 	LoggedIn          bool         // Is this user logged in?
 	LastVisitedPage   string       // Remember what the user was looking at (used when an action is requested)
 	DisplayOption     string       // price, value or size TODO make this type-safe? Probably overkill
+	ViewAs            string       // Admin only: the user whose simulation the admin is viewing (read-only). Empty if none. See ViewKey.
+	SimulationQuota   *int         // Set by the admin to override config.SimulationQuota for this user. Nil means use the default.
 	SimulationList    []Simulation // all the simulations this user has created
 	CommodityList     []Commodity  // all the commodity objects this user has created
 	IndustryList      []Industry   // ...
@@ -26,6 +34,9 @@ type UserData struct {
 	IndustryStockList []Industry_Stock
 	ClassStockList    []Class_Stock
 	TraceList         []Trace
+	History           []Transition     // what the user's most recent actions did, oldest first
+	LocalRuns         map[int]LocalRun // simulations changed by the local engine, by id. See models.localruns.go.
+	UnseenAction      bool             // true if the user has not yet been shown what their last action did
 }
 
 // Format of responses from the server for post requests
@@ -45,17 +56,122 @@ type UserServerData struct {
 	Is_logged_in      bool   `json:"is_logged_in"`
 }
 
-// Transitory variable used to pick up information about the user from the server
-var UserServerItem UserServerData
-
 // Messages to the user are stored here and should be displayed by the relevant page handler
 type UserMessage struct {
 	StatusCode int
 	Message    string
 }
 
-// contains the details of every user's simulations and their status, accessed by username
-var Users = make(map[string]*UserData)
+// contains the details of every user's simulations and their status, accessed by username.
+// It is shared by every request and by the background refreshes, so use the functions below, which lock it.
+var users = make(map[string]*UserData)
+var usersLock sync.RWMutex
+
+// One lock for each user, held by whatever is reading or changing that user's data:
+// the request the user is making, or something working for them in the background.
+// The lock is separate from UserData, which is often copied.
+var userLocks = make(map[string]*sync.Mutex)
+
+// Locks the data of the user called name, and returns the function that unlocks it.
+// Locks are not reentrant: a goroutine that holds one user's lock must not ask for it again.
+func LockUser(name string) (unlock func()) {
+	usersLock.Lock()
+	lock, ok := userLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		userLocks[name] = lock
+	}
+	usersLock.Unlock()
+	lock.Lock()
+	return lock.Unlock
+}
+
+// Copies of other users' tables, fetched for the admin to look at, by ViewKey.
+// They are kept apart from users, so that looking at a simulation never changes its owner's record.
+var views = make(map[string]*UserData)
+
+// The name under which viewer's copy of subject's tables is kept.
+// It contains spaces, which no username may, so it never clashes with a user.
+func ViewKey(viewer string, subject string) string {
+	return subject + " (viewed by " + viewer + ")"
+}
+
+// Keeps view, the tables of another user, under key (see ViewKey); or, if view is nil, discards them
+func SetView(key string, view *UserData) {
+	usersLock.Lock()
+	defer usersLock.Unlock()
+	if view == nil {
+		delete(views, key)
+		return
+	}
+	views[key] = view
+}
+
+// Returns the user called name, or nil if there is no such user.
+// If name is a ViewKey, returns the copy of the tables kept under it.
+func User(name string) *UserData {
+	usersLock.RLock()
+	defer usersLock.RUnlock()
+	if user, ok := users[name]; ok {
+		return user
+	}
+	return views[name]
+}
 
-// List of basic user data, for use by the administrator
-var AdminUserList []UserData
+// Returns the user called name, and whether there is such a user
+func LookupUser(name string) (*UserData, bool) {
+	usersLock.RLock()
+	defer usersLock.RUnlock()
+	user, ok := users[name]
+	return user, ok
+}
+
+// Adds user under user.UserName, replacing any user of that name
+func AddUser(user *UserData) {
+	usersLock.Lock()
+	defer usersLock.Unlock()
+	users[user.UserName] = user
+}
+
+// Adds user under user.UserName, unless there is already a user of that name.
+// Returns true if the user was added.
+func AddUserIfAbsent(user *UserData) bool {
+	usersLock.Lock()
+	defer usersLock.Unlock()
+	if _, ok := users[user.UserName]; ok {
+		return false
+	}
+	users[user.UserName] = user
+	return true
+}
+
+// Returns all the users, in order of name
+func AllUsers() []*UserData {
+	usersLock.RLock()
+	all := make([]*UserData, 0, len(users))
+	for _, user := range users {
+		all = append(all, user)
+	}
+	usersLock.RUnlock()
+	sort.Slice(all, func(i, j int) bool { return all[i].UserName < all[j].UserName })
+	return all
+}
+
+// List of basic user data, for use by the administrator.
+// Use AdminUsers and SetAdminUsers, which lock it.
+var adminUserList []UserData
+var adminUsersLock sync.RWMutex
+
+// Returns a copy of the list of users known to the server
+func AdminUsers() []UserData {
+	adminUsersLock.RLock()
+	defer adminUsersLock.RUnlock()
+	return slices.Clone(adminUserList)
+}
+
+// Replaces the list of users known to the server
+func SetAdminUsers(list []UserData) {
+	adminUsersLock.Lock()
+	defer adminUsersLock.Unlock()
+	adminUserList = list
+}
@@ -0,0 +1,220 @@
+// persist.sessions.go
+// saves and restores the state of each user's session: who they are, their
+// (encrypted) token, which simulation they are using and so on, and their tables.
+// The tables downloaded from the server are fetched again when the session is restored,
+// but History and LocalRuns, which only this frontend has, are restored as they were saved.
+
+package persist
+
+import (
+	"capfront/logging"
+	"capfront/models"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Where sessions are kept. Nil if sessions are not persisted.
+var Sessions Store
+
+// prefixes of the keys under which sessions, and users' tables, are stored
+const sessionPrefix = "user/"
+const tablesPrefix = "tables/"
+
+// What is saved of one user's session
+type session struct {
+	UserName          string
+	LoggedIn          bool
+	CurrentSimulation int
+	LastVisitedPage   string
+	DisplayOption     string
+	SimulationQuota   *int
+	Token             string // encrypted by sealToken; empty if there is no key to encrypt it with
+}
+
+// What is saved of one user's tables
+type tables struct {
+	SimulationList    []models.Simulation
+	CommodityList     []models.Commodity
+	IndustryList      []models.Industry
+	ClassList         []models.Class
+	IndustryStockList []models.Industry_Stock
+	ClassStockList    []models.Class_Stock
+	TraceList         []models.Trace
+	History           []models.Transition
+	LocalRuns         map[int]models.LocalRun
+	UnseenAction      bool
+}
+
+// The session last saved for each user, and a digest of the tables last saved,
+// so that each is written only when it changes.
+// The lock also makes sure that saves happen one at a time.
+var saved = make(map[string]session)
+var savedTables = make(map[string][sha256.Size]byte)
+var savedLock sync.Mutex
+
+// helper function giving what is to be saved of user's tables
+func tablesOf(user *models.UserData) tables {
+	return tables{
+		SimulationList:    user.SimulationList,
+		CommodityList:     user.CommodityList,
+		IndustryList:      user.IndustryList,
+		ClassList:         user.ClassList,
+		IndustryStockList: user.IndustryStockList,
+		ClassStockList:    user.ClassStockList,
+		TraceList:         user.TraceList,
+		History:           user.History,
+		LocalRuns:         user.LocalRuns,
+		UnseenAction:      user.UnseenAction,
+	}
+}
+
+// helper function that puts saved tables back into user
+func (t tables) restore(user *models.UserData) {
+	user.SimulationList = t.SimulationList
+	user.CommodityList = t.CommodityList
+	user.IndustryList = t.IndustryList
+	user.ClassList = t.ClassList
+	user.IndustryStockList = t.IndustryStockList
+	user.ClassStockList = t.ClassStockList
+	user.TraceList = t.TraceList
+	user.History = t.History
+	user.LocalRuns = t.LocalRuns
+	user.UnseenAction = t.UnseenAction
+}
+
+// helper function giving what is to be saved of user
+func sessionOf(user *models.UserData) session {
+	return session{
+		UserName:          user.UserName,
+		LoggedIn:          user.LoggedIn,
+		CurrentSimulation: user.CurrentSimulation,
+		LastVisitedPage:   user.LastVisitedPage,
+		DisplayOption:     user.DisplayOption,
+		SimulationQuota:   user.SimulationQuota,
+		Token:             user.Token,
+	}
+}
+
+// helper function: true if nothing to be saved differs between a and b
+func sameSession(a session, b session) bool {
+	quotaA, quotaB := a.SimulationQuota, b.SimulationQuota
+	a.SimulationQuota, b.SimulationQuota = nil, nil
+	if a != b || (quotaA == nil) != (quotaB == nil) {
+		return false
+	}
+	return quotaA == nil || *quotaA == *quotaB
+}
+
+// Saves the session and the tables of one user, each only if it has changed since it was last saved.
+// The caller must hold the user's lock (see models.LockUser), as every request does.
+// The admin's session is not saved, because the admin logs in afresh at startup.
+func SaveUser(user *models.UserData) {
+	if Sessions == nil || user == nil || user.UserName == "admin" {
+		return
+	}
+	current := sessionOf(user)
+	data, err := json.Marshal(tablesOf(user))
+	if err != nil {
+		logging.Warn("Could not encode tables for saving", "user", user.UserName, "error", err)
+	}
+	digest := sha256.Sum256(data)
+
+	savedLock.Lock()
+	defer savedLock.Unlock()
+	if previous, ok := saved[user.UserName]; !ok || !sameSession(previous, current) {
+		record := current
+		record.Token = sealToken(current.Token)
+		if err := Sessions.Save(sessionPrefix+user.UserName, record); err != nil {
+			logging.Warn("Could not save session", "user", user.UserName, "error", err)
+		} else {
+			saved[user.UserName] = current
+		}
+	}
+	if previous, ok := savedTables[user.UserName]; err == nil && (!ok || previous != digest) {
+		if err := Sessions.Save(tablesPrefix+user.UserName, json.RawMessage(data)); err != nil {
+			logging.Warn("Could not save tables", "user", user.UserName, "error", err)
+		} else {
+			savedTables[user.UserName] = digest
+		}
+	}
+}
+
+// Saves the sessions of all users
+func SaveAll() {
+	for _, user := range models.AllUsers() {
+		unlock := models.LockUser(user.UserName)
+		SaveUser(user)
+		unlock()
+	}
+}
+
+// Restores the saved sessions, and the tables saved with them, into the list of users.
+// Each session is passed to valid, which should check it with the server and fetch the user's tables.
+// A session that fails the check is kept only as a logged-out user with none of the server's tables,
+// so that nothing stale is ever shown. So is a session whose token could not be decrypted.
+// Its History and LocalRuns are kept, since nobody else has them; LocalRuns are laid over
+// the server's tables again when the user next logs in.
+// Returns the number of sessions restored intact.
+func RestoreAll(valid func(user *models.UserData) bool) int {
+	if Sessions == nil {
+		return 0
+	}
+	keys, err := Sessions.Keys()
+	if err != nil {
+		logging.Warn("Could not list saved sessions", "error", err)
+		return 0
+	}
+	restored := 0
+	for _, key := range keys {
+		username, ok := strings.CutPrefix(key, sessionPrefix)
+		if !ok || username == "admin" {
+			continue
+		}
+		var record session
+		if err := Sessions.Load(key, &record); err != nil {
+			logging.Warn("Could not load saved session", "user", username, "error", err)
+			continue
+		}
+		user := models.UserData{
+			UserName:          username,
+			LoggedIn:          record.LoggedIn,
+			CurrentSimulation: record.CurrentSimulation,
+			LastVisitedPage:   record.LastVisitedPage,
+			DisplayOption:     record.DisplayOption,
+			SimulationQuota:   record.SimulationQuota,
+			UserMessage:       &models.UserMessage{},
+		}
+		if record.Token != "" {
+			if token, err := openToken(record.Token); err == nil {
+				user.Token = token
+			} else {
+				logging.Info("Could not decrypt saved token", "user", username, "error", err)
+			}
+		}
+		var stored tables
+		if err := Sessions.Load(tablesPrefix+username, &stored); err == nil {
+			stored.restore(&user)
+		} else if !errors.Is(err, ErrNotFound) {
+			logging.Warn("Could not load saved tables", "user", username, "error", err)
+		}
+
+		// Requests are already being served, so hold the user's lock while checking the session
+		unlock := models.LockUser(username)
+		models.AddUser(&user)
+		ok = valid(&user)
+		if !ok {
+			logging.Info("Saved session is no longer valid; user must log in again", "user", username)
+			blank := &models.UserData{UserName: username, SimulationQuota: user.SimulationQuota, History: user.History, LocalRuns: user.LocalRuns}
+			models.AddUser(blank)
+			SaveUser(blank)
+		}
+		unlock()
+		if ok {
+			restored++
+		}
+	}
+	return restored
+}
@@ -0,0 +1,103 @@
+// persist.sessions_test.go
+// tests that sessions, and the tables saved with them, survive a restart
+
+package persist
+
+import (
+	"capfront/models"
+	"testing"
+)
+
+// helper that saves sessions in a fresh store, putting everything back when the test ends
+func useFreshStore(t *testing.T) {
+	store, err := OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := Sessions
+	Sessions = store
+	t.Cleanup(func() { Sessions = old })
+}
+
+// helper that makes a logged-in user with some tables, one local run and one transition
+func savedUser(name string) *models.UserData {
+	user := &models.UserData{
+		UserName:          name,
+		LoggedIn:          true,
+		Token:             "token of " + name,
+		CurrentSimulation: 3,
+		SimulationList:    []models.Simulation{{Id: 3, Time_Stamp: 2}},
+		CommodityList:     []models.Commodity{{Id: 30, Simulation_id: 3, Size: 50}},
+		History:           []models.Transition{{Action: "demand"}},
+		UnseenAction:      true,
+	}
+	user.KeepLocalRun(3)
+	return user
+}
+
+func TestRestoreAll(t *testing.T) {
+	useFreshStore(t)
+	useSessionKey(t, "a secret")
+	SaveUser(savedUser("restoregood"))
+	SaveUser(savedUser("restorebad"))
+
+	var seen = make(map[string]models.UserData)
+	restored := RestoreAll(func(user *models.UserData) bool {
+		seen[user.UserName] = *user
+		return user.UserName == "restoregood"
+	})
+	if restored != 1 {
+		t.Errorf("restored %d sessions, want 1", restored)
+	}
+
+	// valid is shown the session as it was saved, tables and all
+	for _, name := range []string{"restoregood", "restorebad"} {
+		user := seen[name]
+		if !user.LoggedIn || user.Token != "token of "+name || user.CurrentSimulation != 3 {
+			t.Errorf("%s: session not restored: %+v", name, user)
+		}
+		if len(user.CommodityList) != 1 || user.CommodityList[0].Size != 50 || len(user.History) != 1 || len(user.LocalRuns) != 1 {
+			t.Errorf("%s: tables not restored: %+v", name, user)
+		}
+	}
+
+	good := models.User("restoregood")
+	if !good.LoggedIn || len(good.SimulationList) != 1 || len(good.LocalRuns) != 1 {
+		t.Errorf("the valid session was not kept: %+v", good)
+	}
+
+	// The rejected session keeps only what the server cannot give back
+	bad := models.User("restorebad")
+	if bad.LoggedIn || bad.Token != "" || len(bad.SimulationList) != 0 || len(bad.CommodityList) != 0 {
+		t.Errorf("the rejected session kept what it should not: %+v", bad)
+	}
+	if len(bad.History) != 1 || len(bad.LocalRuns) != 1 {
+		t.Errorf("the rejected session lost its history or local runs: %+v", bad)
+	}
+
+	// and is saved like that, so it stays rejected after another restart
+	var record session
+	if err := Sessions.Load(sessionPrefix+"restorebad", &record); err != nil || record.LoggedIn || record.Token != "" {
+		t.Errorf("the rejected session was saved as %+v, %v", record, err)
+	}
+}
+
+func TestRestoreAllWithoutKey(t *testing.T) {
+	useFreshStore(t)
+	useSessionKey(t, "")
+	SaveUser(savedUser("restorenokey"))
+	RestoreAll(func(user *models.UserData) bool {
+		if user.Token != "" {
+			t.Errorf("a token was restored although there was no key to encrypt it: %q", user.Token)
+		}
+		return false
+	})
+}
+
+func TestSaveUserSkipsAdmin(t *testing.T) {
+	useFreshStore(t)
+	SaveUser(&models.UserData{UserName: "admin", LoggedIn: true})
+	if keys, _ := Sessions.Keys(); len(keys) != 0 {
+		t.Errorf("the admin's session was saved: %q", keys)
+	}
+}
@@ -0,0 +1,121 @@
+// persist.store.go
+// keeps things between restarts of this frontend.
+// Store is the interface; FileStore is a simple implementation that keeps
+// each key as a JSON file in a directory.
+
+package persist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Returned by Load when there is nothing stored under the key
+var ErrNotFound = errors.New("not found in store")
+
+// A key-value store for anything that can be marshalled to JSON
+type Store interface {
+	Save(key string, value any) error
+	Load(key string, value any) error // returns ErrNotFound if there is no such key
+	Delete(key string) error
+	Keys() ([]string, error)
+	Close() error
+}
+
+// A Store that keeps each key in its own file in Dir.
+// Files are replaced atomically, so a crash mid-write leaves the previous version intact.
+type FileStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// Opens a FileStore in dir, creating the directory if need be
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create store %s: %w", dir, err)
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+// helper function giving the file that holds key.
+// Keys are escaped so that, for example, a '/' in a key cannot escape Dir.
+func (s *FileStore) fileName(key string) string {
+	return filepath.Join(s.Dir, url.PathEscape(key)+".json")
+}
+
+func (s *FileStore) Save(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	temp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), s.fileName(key))
+}
+
+func (s *FileStore) Load(key string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.fileName(key))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.fileName(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *FileStore) Keys() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if key, err := url.PathUnescape(name); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Nothing to flush: every Save is already on disk
+func (s *FileStore) Close() error {
+	return nil
+}
@@ -0,0 +1,87 @@
+// persist.store_test.go
+// tests that a FileStore gives back what was saved in it
+
+package persist
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+type storedThing struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "nested", "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"user/alice", "tables/alice", "../escape", "spaced key"}
+	for i, key := range keys {
+		if err := store.Save(key, storedThing{Name: key, Count: i, Tags: []string{"a", "b"}}); err != nil {
+			t.Fatalf("Save(%q): %v", key, err)
+		}
+	}
+	for i, key := range keys {
+		var got storedThing
+		if err := store.Load(key, &got); err != nil {
+			t.Fatalf("Load(%q): %v", key, err)
+		}
+		if got.Name != key || got.Count != i || !slices.Equal(got.Tags, []string{"a", "b"}) {
+			t.Errorf("Load(%q) = %+v", key, got)
+		}
+	}
+
+	listed, err := store.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(listed)
+	want := slices.Clone(keys)
+	slices.Sort(want)
+	if !slices.Equal(listed, want) {
+		t.Errorf("Keys() = %q, want %q", listed, want)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(store.Dir), "escape.json")); err == nil {
+		t.Error("a key containing ../ was stored outside the store's directory")
+	}
+
+	// Saving again replaces the value
+	if err := store.Save("user/alice", storedThing{Name: "replaced"}); err != nil {
+		t.Fatal(err)
+	}
+	var got storedThing
+	if store.Load("user/alice", &got); got.Name != "replaced" {
+		t.Errorf("after a second Save, Load gave %+v", got)
+	}
+
+	if err := store.Delete("user/alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Load("user/alice", &got); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load after Delete gave %v, want ErrNotFound", err)
+	}
+	if err := store.Delete("user/alice"); err != nil {
+		t.Errorf("deleting a missing key gave %v", err)
+	}
+}
+
+// Temporary files left by an interrupted Save are not keys
+func TestFileStoreIgnoresTemporaryFiles(t *testing.T) {
+	store, err := OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(store.Dir, ".tmp-123"), []byte("{"), 0o600)
+	store.Save("kept", 1)
+	keys, err := store.Keys()
+	if err != nil || !slices.Equal(keys, []string{"kept"}) {
+		t.Errorf("Keys() = %q, %v; want [kept]", keys, err)
+	}
+}
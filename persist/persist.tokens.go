@@ -0,0 +1,64 @@
+// persist.tokens.go
+// encrypts the access tokens kept in saved sessions, so that whoever can read
+// the session files cannot use them to act as the users.
+
+package persist
+
+import (
+	"capfront/config"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// helper function giving the cipher made from config.SessionKey, or nil if there is no key
+func tokenCipher() cipher.AEAD {
+	if config.SessionKey == "" {
+		return nil
+	}
+	key := sha256.Sum256([]byte(config.SessionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil
+	}
+	return aead
+}
+
+// Encrypts token for saving.
+// Returns "" if there is no key, so that the token is not saved at all.
+func sealToken(token string) string {
+	aead := tokenCipher()
+	if aead == nil || token == "" {
+		return ""
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(token), nil))
+}
+
+// Decrypts a token encrypted by sealToken.
+// Fails if there is no key, or if the token was encrypted with a different one.
+func openToken(sealed string) (string, error) {
+	aead := tokenCipher()
+	if aead == nil {
+		return "", errors.New("no key to decrypt saved tokens")
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("saved token is malformed")
+	}
+	token, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
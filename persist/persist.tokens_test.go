@@ -0,0 +1,67 @@
+// persist.tokens_test.go
+// tests of the encryption of saved tokens
+
+package persist
+
+import (
+	"capfront/config"
+	"encoding/base64"
+	"testing"
+)
+
+// helper that sets config.SessionKey, restoring it when the test ends
+func useSessionKey(t *testing.T, key string) {
+	old := config.SessionKey
+	config.SessionKey = key
+	t.Cleanup(func() { config.SessionKey = old })
+}
+
+func TestSealAndOpenToken(t *testing.T) {
+	useSessionKey(t, "a secret")
+	sealed := sealToken("the token")
+	if sealed == "" || sealed == "the token" {
+		t.Fatalf("sealToken gave %q", sealed)
+	}
+	if again := sealToken("the token"); again == sealed {
+		t.Error("the same token was sealed the same way twice")
+	}
+	token, err := openToken(sealed)
+	if err != nil || token != "the token" {
+		t.Errorf("openToken gave %q, %v", token, err)
+	}
+}
+
+func TestOpenTokenFails(t *testing.T) {
+	useSessionKey(t, "a secret")
+	sealed := sealToken("the token")
+	data, _ := base64.StdEncoding.DecodeString(sealed)
+	data[len(data)-1] ^= 1
+	tampered := base64.StdEncoding.EncodeToString(data)
+
+	tests := []struct {
+		name   string
+		key    string
+		sealed string
+	}{
+		{"tampered", "a secret", tampered},
+		{"wrong key", "another secret", sealed},
+		{"no key", "", sealed},
+		{"not base64", "a secret", "!!!"},
+		{"too short", "a secret", base64.StdEncoding.EncodeToString([]byte("short"))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config.SessionKey = test.key
+			if token, err := openToken(test.sealed); err == nil {
+				t.Errorf("openToken succeeded, giving %q", token)
+			}
+		})
+	}
+}
+
+func TestSealTokenWithoutKey(t *testing.T) {
+	useSessionKey(t, "")
+	if sealed := sealToken("the token"); sealed != "" {
+		t.Errorf("with no key, sealToken gave %q; want nothing saved", sealed)
+	}
+}
@@ -0,0 +1,10 @@
+// capfront.js
+// scripts shared by the pages of the simulation
+
+// Our tables are small and shown whole, so by default
+// DataTables should not paginate, search or summarise them.
+$.extend(true, $.fn.dataTable.defaults, {
+  info: false,
+  paging: false,
+  searching: false,
+});
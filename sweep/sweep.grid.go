@@ -0,0 +1,166 @@
+// sweep.grid.go
+// describes a parameter sweep: which parameters are varied, over what ranges,
+// and which indicators are collected at each point of the grid.
+
+package sweep
+
+import (
+	"capfront/models"
+	"fmt"
+	"slices"
+)
+
+// The most points a sweep may have, since each one is a simulation run on the server
+const MaxPoints = 100
+
+// The most periods each simulation in a sweep may run for
+const MaxPeriods = 50
+
+// A parameter that a sweep may vary, and how to set it
+type Parameter struct {
+	Name  string // as in models.SimulationParameters' JSON names
+	Label string
+	set   func(*models.SimulationParameters, float32)
+}
+
+// The parameters that a sweep may vary: the numeric ones that a user may change when cloning
+var Parameters = []Parameter{
+	{"investment_ratio", "Investment ratio", func(p *models.SimulationParameters, v float32) { p.Investment_Ratio = v }},
+	{"population_growth_rate", "Population growth rate", func(p *models.SimulationParameters, v float32) { p.Population_Growth_Rate = v }},
+	{"periods_per_year", "Periods per year", func(p *models.SimulationParameters, v float32) { p.Periods_Per_Year = v }},
+}
+
+// Finds a parameter by name, or returns nil
+func FindParameter(name string) *Parameter {
+	i := slices.IndexFunc(Parameters, func(p Parameter) bool { return p.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return &Parameters[i]
+}
+
+// The values a parameter takes in a sweep: Steps values evenly spaced from From to To inclusive
+type Axis struct {
+	Parameter string
+	From      float32
+	To        float32
+	Steps     int
+}
+
+// The values along the axis, in order
+func (a Axis) Values() []float32 {
+	if a.Steps <= 1 {
+		return []float32{a.From}
+	}
+	values := make([]float32, a.Steps)
+	for i := range values {
+		values[i] = a.From + (a.To-a.From)*float32(i)/float32(a.Steps-1)
+	}
+	return values
+}
+
+// What a sweep is to do.
+// Y is optional; without it the sweep varies one parameter.
+type Spec struct {
+	Template   models.Simulation
+	X          Axis
+	Y          *Axis
+	Periods    int
+	Indicators []string
+}
+
+// One point of the grid: the values of the varied parameters
+type Point struct {
+	X float32
+	Y float32 // zero if the sweep varies one parameter
+}
+
+// The number of values along the axis
+func (a Axis) Size() int {
+	return max(a.Steps, 1)
+}
+
+// The number of points in the grid, found without making them
+func (s Spec) Size() int {
+	if s.Y == nil {
+		return s.X.Size()
+	}
+	return s.X.Size() * s.Y.Size()
+}
+
+// The points of the grid, with X varying fastest
+func (s Spec) Points() []Point {
+	ys := []float32{0}
+	if s.Y != nil {
+		ys = s.Y.Values()
+	}
+	var points []Point
+	for _, y := range ys {
+		for _, x := range s.X.Values() {
+			points = append(points, Point{X: x, Y: y})
+		}
+	}
+	return points
+}
+
+// The parameters of the simulation to be cloned for a point:
+// those of the template, with the varied parameters changed.
+func (s Spec) ParametersAt(p Point) models.SimulationParameters {
+	parameters := s.Template.Parameters()
+	FindParameter(s.X.Parameter).set(&parameters, p.X)
+	if s.Y != nil {
+		FindParameter(s.Y.Parameter).set(&parameters, p.Y)
+	}
+	return parameters
+}
+
+// Checks that the sweep can be run.
+// Returns a message for each fault, keyed by the name of the form field concerned; empty if all is well.
+func (s Spec) Validate() map[string]string {
+	faults := make(map[string]string)
+	checkAxis := func(field string, a Axis) {
+		if FindParameter(a.Parameter) == nil {
+			faults[field] = "Please choose a parameter"
+			return
+		}
+		if a.Steps < 1 {
+			faults[field] = "There must be at least one step"
+			return
+		}
+		if a.Steps > MaxPoints {
+			faults[field] = fmt.Sprintf("There can be at most %d steps", MaxPoints)
+			return
+		}
+		// Every point must be a simulation that a user could have created by hand
+		for _, v := range []float32{a.From, a.To} {
+			parameters := s.Template.Parameters()
+			FindParameter(a.Parameter).set(&parameters, v)
+			if fault, ok := parameters.Validate()[a.Parameter]; ok {
+				faults[field] = fault
+				return
+			}
+		}
+	}
+	checkAxis("x", s.X)
+	if s.Y != nil {
+		checkAxis("y", *s.Y)
+		if s.Y.Parameter == s.X.Parameter {
+			faults["y"] = "Please choose a different parameter from the first"
+		}
+	}
+	if n := s.Size(); n > MaxPoints && faults["x"] == "" && faults["y"] == "" {
+		faults["x"] = fmt.Sprintf("The sweep has %d points; the most allowed is %d", n, MaxPoints)
+	}
+	if s.Periods < 1 || s.Periods > MaxPeriods {
+		faults["periods"] = fmt.Sprintf("Must be between 1 and %d", MaxPeriods)
+	}
+	if len(s.Indicators) == 0 {
+		faults["indicators"] = "Please choose at least one indicator"
+	}
+	for _, name := range s.Indicators {
+		if FindIndicator(name) == nil {
+			faults["indicators"] = fmt.Sprintf("There is no indicator called %q", name)
+		}
+	}
+	return faults
+}
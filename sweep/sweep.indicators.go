@@ -0,0 +1,75 @@
+// sweep.indicators.go
+// the measurements that a sweep collects from each simulation at the end of its run
+
+package sweep
+
+import (
+	"capfront/models"
+	"slices"
+)
+
+// The tables of one simulation at the end of its run
+type Outcome struct {
+	Commodities []models.Commodity
+	Industries  []models.Industry
+	Classes     []models.Class
+}
+
+// Something measured at the end of a run
+type Indicator struct {
+	Name    string
+	Label   string
+	measure func(Outcome) float64
+}
+
+// The indicators a sweep may collect
+var Indicators = []Indicator{
+	{"profit_rate", "Profit rate", profitRate},
+	{"profit", "Total profit", func(o Outcome) float64 {
+		return sumIndustries(o, func(i models.Industry) float32 { return i.Profit })
+	}},
+	{"capital", "Total capital", func(o Outcome) float64 {
+		return sumIndustries(o, func(i models.Industry) float32 { return i.Current_Capital })
+	}},
+	{"output", "Total output scale", func(o Outcome) float64 {
+		return sumIndustries(o, func(i models.Industry) float32 { return i.Output_Scale })
+	}},
+	{"population", "Total population", func(o Outcome) float64 {
+		total := 0.0
+		for _, c := range o.Classes {
+			total += float64(c.Population)
+		}
+		return total
+	}},
+}
+
+// Finds an indicator by name, or returns nil
+func FindIndicator(name string) *Indicator {
+	i := slices.IndexFunc(Indicators, func(ind Indicator) bool { return ind.Name == name })
+	if i < 0 {
+		return nil
+	}
+	return &Indicators[i]
+}
+
+// Measures this indicator for one simulation
+func (ind Indicator) Measure(o Outcome) float64 {
+	return ind.measure(o)
+}
+
+// The general rate of profit: total profit over total initial capital, or zero if there is no capital
+func profitRate(o Outcome) float64 {
+	capital := sumIndustries(o, func(i models.Industry) float32 { return i.Initial_Capital })
+	if capital == 0 {
+		return 0
+	}
+	return sumIndustries(o, func(i models.Industry) float32 { return i.Profit }) / capital
+}
+
+func sumIndustries(o Outcome, field func(models.Industry) float32) float64 {
+	total := 0.0
+	for _, i := range o.Industries {
+		total += float64(field(i))
+	}
+	return total
+}
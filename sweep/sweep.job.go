@@ -0,0 +1,250 @@
+// sweep.job.go
+// runs a sweep in the background, one simulation at a time, and keeps its results.
+// Each user may have one sweep at a time; it is kept until the user starts another or clears it.
+
+package sweep
+
+import (
+	"capfront/audit"
+	"capfront/logging"
+	"capfront/models"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The actions of one period, in the order the user's buttons take them
+var Cycle = []string{"demand", "supply", "trade", "produce", "consume", "invest"}
+
+type State string
+
+const (
+	Running   State = "running"
+	Finished  State = "finished"
+	Cancelled State = "cancelled"
+	Failed    State = "failed"
+)
+
+// The indicators measured at one point, in the order of Spec.Indicators
+type Result struct {
+	Point
+	Values []float64
+}
+
+// A sweep, running or done
+type Job struct {
+	User    string
+	Spec    Spec
+	Started time.Time
+
+	mu        sync.Mutex
+	state     State
+	err       error
+	ended     time.Time
+	results   []Result
+	leftovers []int // simulations that could not be deleted
+	previous  int   // the user's current simulation before the sweep, to go back to afterwards
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// What a job has done so far, safe to display while it runs
+type Report struct {
+	User      string
+	Spec      Spec
+	State     State
+	Err       error
+	Started   time.Time
+	Ended     time.Time
+	Results   []Result
+	Total     int
+	Leftovers []int
+}
+
+var (
+	jobsMu       sync.Mutex
+	jobs         = make(map[string]*Job)
+	shuttingDown bool // set by Shutdown, after which no sweep may start
+)
+
+// Starts a sweep for the user, replacing any finished sweep.
+// Refuses if the user already has a sweep running or if the spec is faulty.
+// Must be called by a request of the user, which holds the lock on their data,
+// because it notes which simulation the user is using so as to go back to it afterwards.
+func Start(backend Backend, username string, spec Spec) (*Job, error) {
+	if faults := spec.Validate(); len(faults) > 0 {
+		return nil, fmt.Errorf("the sweep is not properly specified")
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if shuttingDown {
+		return nil, fmt.Errorf("the frontend is shutting down")
+	}
+	if old, ok := jobs[username]; ok && old.Report().State == Running {
+		return nil, fmt.Errorf("a sweep is already running")
+	}
+	previous := 0
+	if user := models.User(username); user != nil {
+		previous = user.CurrentSimulation
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{User: username, Spec: spec, Started: time.Now(), state: Running, previous: previous, cancel: cancel, done: make(chan struct{})}
+	jobs[username] = job
+	go job.run(ctx, backend)
+	return job, nil
+}
+
+// The user's sweep, running or done, or nil if there is none
+func Current(username string) *Job {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return jobs[username]
+}
+
+// True if the user has a sweep running.
+// While it runs the server's idea of the user's current simulation keeps changing,
+// so the user should not take actions of their own.
+func IsRunning(username string) bool {
+	job := Current(username)
+	return job != nil && job.Report().State == Running
+}
+
+// Forgets the user's sweep, unless it is still running
+func Clear(username string) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if job, ok := jobs[username]; ok && job.Report().State != Running {
+		delete(jobs, username)
+	}
+}
+
+// Cancels every sweep that is running and waits for them to stop, but for no longer than timeout.
+// No sweep may start afterwards. Used when the frontend shuts down, so that
+// each sweep deletes its simulation and restores its user before the sessions are saved.
+// Returns false if some sweeps did not stop in time.
+func Shutdown(timeout time.Duration) bool {
+	jobsMu.Lock()
+	shuttingDown = true
+	var running []*Job
+	for _, job := range jobs {
+		job.Cancel()
+		running = append(running, job)
+	}
+	jobsMu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, job := range running {
+		select {
+		case <-job.done:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
+
+// Asks the job to stop. It finishes the point it is working on, deletes the simulation,
+// and then stops. Results collected so far are kept.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Waits for the job to stop
+func (j *Job) Wait() {
+	<-j.done
+}
+
+// A copy of what the job has done so far
+func (j *Job) Report() Report {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Report{
+		User:      j.User,
+		Spec:      j.Spec,
+		State:     j.state,
+		Err:       j.err,
+		Started:   j.Started,
+		Ended:     j.ended,
+		Results:   append([]Result(nil), j.results...),
+		Total:     j.Spec.Size(),
+		Leftovers: append([]int(nil), j.leftovers...),
+	}
+}
+
+func (j *Job) run(ctx context.Context, backend Backend) {
+	defer close(j.done)
+	logging.Info("Sweep started", "user", j.User, "template", j.Spec.Template.Id, "points", j.Spec.Size())
+	var err error
+	for _, point := range j.Spec.Points() {
+		if ctx.Err() != nil {
+			break
+		}
+		var result Result
+		result, err = j.runPoint(ctx, backend, point)
+		if err != nil {
+			break
+		}
+		j.mu.Lock()
+		j.results = append(j.results, result)
+		j.mu.Unlock()
+	}
+
+	state := Finished
+	switch {
+	case ctx.Err() != nil:
+		state = Cancelled
+		err = nil
+	case err != nil:
+		state = Failed
+	}
+	if restoreErr := backend.Restore(j.User, j.previous); restoreErr != nil {
+		logging.Warn("Could not restore the user's tables after a sweep", "user", j.User, "error", restoreErr)
+	}
+	j.mu.Lock()
+	j.state = state
+	j.err = err
+	j.ended = time.Now()
+	points := len(j.results)
+	j.mu.Unlock()
+	audit.Record(j.User, 0, "sweep", err, time.Since(j.Started), fmt.Sprintf("template %d, %s, %d points", j.Spec.Template.Id, state, points))
+	logging.Info("Sweep ended", "user", j.User, "state", state, "points", points, "error", err)
+}
+
+// Clones a simulation for the point, runs it, measures it and deletes it.
+// If the job is cancelled part way through, the simulation is still deleted,
+// and the context's error is returned.
+func (j *Job) runPoint(ctx context.Context, backend Backend, point Point) (Result, error) {
+	result := Result{Point: point}
+	id, err := backend.Clone(j.User, j.Spec.Template.Id, j.Spec.ParametersAt(point))
+	if err != nil {
+		return result, fmt.Errorf("could not create a simulation: %w", err)
+	}
+	defer func() {
+		if err := backend.Delete(j.User, id); err != nil {
+			logging.Warn("Could not delete a simulation created for a sweep", "user", j.User, "simulation", id, "error", err)
+			j.mu.Lock()
+			j.leftovers = append(j.leftovers, id)
+			j.mu.Unlock()
+		}
+	}()
+
+	for period := 0; period < j.Spec.Periods; period++ {
+		for _, action := range Cycle {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			if err := backend.Act(j.User, action); err != nil {
+				return result, fmt.Errorf("%s failed in period %d: %w", action, period+1, err)
+			}
+		}
+	}
+	outcome, err := backend.Outcome(j.User, id)
+	if err != nil {
+		return result, fmt.Errorf("could not fetch the results: %w", err)
+	}
+	for _, name := range j.Spec.Indicators {
+		result.Values = append(result.Values, FindIndicator(name).Measure(outcome))
+	}
+	return result, nil
+}
@@ -0,0 +1,129 @@
+// sweep.remote.go
+// runs the simulations of a sweep on the remote server, as the user who asked for the sweep
+
+package sweep
+
+import (
+	"capfront/api"
+	"capfront/auth"
+	"capfront/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// What a sweep needs the server to do.
+// The server acts on the user's current simulation, so Act applies to the simulation most recently cloned.
+// Restore puts the user back on the simulation they were using before the sweep.
+type Backend interface {
+	Clone(username string, templateID int, parameters models.SimulationParameters) (simulationID int, err error)
+	Act(username string, action string) error
+	Outcome(username string, simulationID int) (Outcome, error)
+	Delete(username string, simulationID int) error
+	Restore(username string, simulationID int) error
+}
+
+// The remote server.
+// Each of its methods holds the lock on the user's data while it works, since the user's
+// own requests go on while the sweep runs.
+var Remote Backend = remote{}
+
+type remote struct{}
+
+// Clones the template with the given parameters and returns the id of the new simulation,
+// which the server makes the user's current simulation.
+// The parameters are POSTed as in display.cloneTemplate, which the server may not support.
+func (remote) Clone(username string, templateID int, parameters models.SimulationParameters) (int, error) {
+	defer models.LockUser(username)()
+	_, err := auth.ProtectedJSONServerRequest(context.Background(), username, "create a simulation for a sweep", `users/clone/`+strconv.Itoa(templateID), parameters)
+	if auth.NotSupported(err) {
+		// An ordinary clone would give every point of the grid the same parameters
+		return 0, fmt.Errorf("the server does not accept changed parameters when cloning, so it cannot run sweeps: %w", err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	body, err := auth.ProtectedResourceServerRequest(context.Background(), username, "find the simulation created for a sweep", `users/`+username)
+	if err != nil {
+		return 0, err
+	}
+	var serverItem models.UserServerData
+	if err := json.Unmarshal(body, &serverItem); err != nil {
+		return 0, fmt.Errorf("could not read the user's details: %w", err)
+	}
+	return serverItem.CurrentSimulation, nil
+}
+
+func (remote) Act(username string, action string) error {
+	defer models.LockUser(username)()
+	_, err := auth.ProtectedResourceServerRequest(context.Background(), username, action, `action/`+action)
+	return err
+}
+
+// Fetches the user's commodities, industries and classes, and keeps those of the given simulation.
+// The user's own tables are not touched, so the pages they see don't change while the sweep runs.
+func (remote) Outcome(username string, simulationID int) (Outcome, error) {
+	defer models.LockUser(username)()
+	var o Outcome
+	var commodities []models.Commodity
+	var industries []models.Industry
+	var classes []models.Class
+	tables := []struct {
+		path string
+		into any
+	}{
+		{`commodities/`, &commodities},
+		{`industries/`, &industries},
+		{`classes/`, &classes},
+	}
+	for _, t := range tables {
+		body, err := auth.ProtectedResourceServerRequest(context.Background(), username, "fetch the results of a sweep", t.path)
+		if err != nil {
+			return o, err
+		}
+		if err := json.Unmarshal(body, t.into); err != nil {
+			return o, fmt.Errorf("could not read %s: %w", t.path, err)
+		}
+	}
+	for _, c := range commodities {
+		if int(c.Simulation_id) == simulationID {
+			o.Commodities = append(o.Commodities, c)
+		}
+	}
+	for _, i := range industries {
+		if int(i.Simulation_id) == simulationID {
+			o.Industries = append(o.Industries, i)
+		}
+	}
+	for _, c := range classes {
+		if int(c.Simulation_id) == simulationID {
+			o.Classes = append(o.Classes, c)
+		}
+	}
+	return o, nil
+}
+
+func (remote) Delete(username string, simulationID int) error {
+	defer models.LockUser(username)()
+	_, err := auth.ProtectedResourceServerRequest(context.Background(), username, "delete a simulation created for a sweep", `simulations/delete/`+strconv.Itoa(simulationID))
+	return err
+}
+
+// Makes simulationID the user's current simulation again, and fetches the user's tables,
+// which now lack the simulations the sweep created and deleted.
+// The server has no way to be told which simulation is current, so it still names the last one
+// the sweep created. userStatus ignores that, because the user no longer has it, and
+// display.ActionHandler refuses to send actions, which would change that simulation, not this one.
+func (remote) Restore(username string, simulationID int) error {
+	defer models.LockUser(username)()
+	user := models.User(username)
+	if user == nil {
+		return fmt.Errorf("we have no record of user %s", username)
+	}
+	user.CurrentSimulation = simulationID
+	if !api.Refresh(context.Background(), username) {
+		return fmt.Errorf("could not fetch the user's tables")
+	}
+	return nil
+}
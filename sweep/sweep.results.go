@@ -0,0 +1,126 @@
+// sweep.results.go
+// presents the results of a sweep as a table, a heatmap or a CSV file
+
+package sweep
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// One cell of a heatmap. Missing is true if the sweep did not reach this point.
+type HeatCell struct {
+	Value   float64
+	Colour  string
+	Missing bool
+}
+
+// One indicator over the grid. With one parameter there is a single row.
+type Heatmap struct {
+	Indicator string
+	XLabel    string
+	YLabel    string
+	XValues   []float32
+	YValues   []float32
+	Rows      [][]HeatCell // Rows[y][x]
+	Min       float64
+	Max       float64
+}
+
+// The labels of the indicators collected, in the order of Result.Values
+func (r Report) IndicatorLabels() []string {
+	var labels []string
+	for _, name := range r.Spec.Indicators {
+		labels = append(labels, FindIndicator(name).Label)
+	}
+	return labels
+}
+
+// The label of the parameter varied along the first axis
+func (r Report) XLabel() string {
+	return FindParameter(r.Spec.X.Parameter).Label
+}
+
+// The label of the parameter varied along the second axis, or "" if there is only one
+func (r Report) YLabel() string {
+	if r.Spec.Y == nil {
+		return ""
+	}
+	return FindParameter(r.Spec.Y.Parameter).Label
+}
+
+// The indicator's values over the grid, shaded from pale (lowest) to dark (highest).
+// Returns false if the sweep did not collect this indicator.
+func (r Report) Heatmap(indicator string) (Heatmap, bool) {
+	column := slices.Index(r.Spec.Indicators, indicator)
+	if column < 0 {
+		return Heatmap{}, false
+	}
+	h := Heatmap{Indicator: FindIndicator(indicator).Label, XValues: r.Spec.X.Values(), YValues: []float32{0}}
+	h.XLabel, h.YLabel = r.XLabel(), r.YLabel()
+	if r.Spec.Y != nil {
+		h.YValues = r.Spec.Y.Values()
+	}
+
+	values := make(map[Point]float64)
+	h.Min, h.Max = math.Inf(1), math.Inf(-1)
+	for _, result := range r.Results {
+		v := result.Values[column]
+		values[result.Point] = v
+		h.Min = math.Min(h.Min, v)
+		h.Max = math.Max(h.Max, v)
+	}
+	for _, y := range h.YValues {
+		var row []HeatCell
+		for _, x := range h.XValues {
+			v, ok := values[Point{X: x, Y: y}]
+			if !ok {
+				row = append(row, HeatCell{Missing: true})
+				continue
+			}
+			row = append(row, HeatCell{Value: v, Colour: shade(v, h.Min, h.Max)})
+		}
+		h.Rows = append(h.Rows, row)
+	}
+	return h, true
+}
+
+// A CSS colour for v, on a scale from pale at min to dark red at max
+func shade(v float64, min float64, max float64) string {
+	fraction := 0.5
+	if max > min {
+		fraction = (v - min) / (max - min)
+	}
+	return fmt.Sprintf("hsl(10, 80%%, %.0f%%)", 95-50*fraction)
+}
+
+// Writes one row per point: the values of the varied parameters, then the indicators
+func (r Report) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	y := r.YLabel()
+	header := []string{r.XLabel()}
+	if y != "" {
+		header = append(header, y)
+	}
+	out.Write(append(header, r.IndicatorLabels()...))
+	for _, result := range r.Results {
+		row := []string{formatFloat(float64(result.X))}
+		if y != "" {
+			row = append(row, formatFloat(float64(result.Y)))
+		}
+		for _, v := range result.Values {
+			row = append(row, formatFloat(v))
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}